@@ -0,0 +1,91 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const abortOnWarningContextKey contextKey = "stx:abort_on_warning"
+
+// ErrWarningLogged is returned when a transaction started in a
+// WithAbortOnWarning scope is rolled back because a warning was logged.
+var ErrWarningLogged = errors.New("stx: transaction aborted after a logged warning")
+
+// warningState records the first warning logged during a transaction
+type warningState struct {
+	mu      sync.Mutex
+	logged  bool
+	message string
+}
+
+// warningLogger is a GORM logger that records warnings into a warningState
+// before passing them on to the next logger
+type warningLogger struct {
+	wrappedLogger
+	state *warningState
+}
+
+func (l *warningLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &warningLogger{wrappedLogger: wrappedLogger{next: l.next.LogMode(level)}, state: l.state}
+}
+
+func (l *warningLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	l.state.mu.Lock()
+	if !l.state.logged {
+		l.state.logged = true
+		l.state.message = fmt.Sprintf(msg, data...)
+	}
+	l.state.mu.Unlock()
+
+	l.next.Warn(ctx, msg, data...)
+}
+
+// WithAbortOnWarning returns a context whose transactions become rollback-only
+// as soon as a warning is logged through the transaction's GORM logger: the
+// transaction is rolled back instead of committed and WithTransaction, Commit
+// and the WithDefer cleanup report ErrWarningLogged with the first warning.
+// This suits strict environments where a warning may indicate a data
+// integrity problem.
+//
+// Only calls to the logger's Warn method count, made by plugins, application
+// code using Current(ctx).Logger, or stx itself, for example the deep nesting
+// warning of SetNestingWarnThreshold. GORM reports most problems as errors
+// instead, and its slow query log goes through Trace, so neither triggers an
+// abort. A warning unrelated to the data, such as from a debugging aid, still
+// aborts the transaction; enable such aids with care in this scope. Warnings
+// in nested transactions abort the enclosing transaction as well.
+func WithAbortOnWarning(ctx context.Context) context.Context {
+	return context.WithValue(ctx, abortOnWarningContextKey, true)
+}
+
+// warningLogged returns ErrWarningLogged if a warning has been logged on the
+// WithAbortOnWarning logger of db
+func warningLogged(db *gorm.DB) error {
+	if db == nil {
+		return nil
+	}
+
+	var state *warningState
+	hasLogger(db.Logger, func(l logger.Interface) bool {
+		if wl, ok := l.(*warningLogger); ok {
+			state = wl.state
+			return true
+		}
+		return false
+	})
+	if state == nil {
+		return nil
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if !state.logged {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrWarningLogged, state.message)
+}
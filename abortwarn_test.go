@@ -0,0 +1,111 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWithAbortOnWarning(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	count := func(name string) int64 {
+		var n int64
+		db.Model(&TestModel{}).Where("name = ?", name).Count(&n)
+		return n
+	}
+	// warn logs through the transaction's logger, as a plugin would
+	warn := func(txCtx context.Context) {
+		Current(txCtx).Logger.Warn(txCtx, "suspicious value %d", 42)
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			warn(txCtx)
+			return Current(txCtx).Create(&TestModel{Name: "lenient"}).Error
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if count("lenient") != 1 {
+			t.Error("expected the transaction to commit")
+		}
+	})
+
+	abortCtx := WithAbortOnWarning(ctx)
+
+	t.Run("WithTransaction", func(t *testing.T) {
+		called := false
+		err := WithTransaction(abortCtx, func(txCtx context.Context) error {
+			OnSuccess(txCtx, func() { called = true })
+			if err := Current(txCtx).Create(&TestModel{Name: "warned"}).Error; err != nil {
+				return err
+			}
+			warn(txCtx)
+			return nil
+		})
+		if !errors.Is(err, ErrWarningLogged) || !strings.Contains(err.Error(), "suspicious value 42") {
+			t.Fatalf("expected ErrWarningLogged with the warning, got %v", err)
+		}
+		if count("warned") != 0 {
+			t.Error("expected the transaction to roll back")
+		}
+		if called {
+			t.Error("expected callbacks not to run")
+		}
+	})
+
+	t.Run("nested warning aborts enclosing transaction", func(t *testing.T) {
+		err := WithTransaction(abortCtx, func(txCtx context.Context) error {
+			if err := Current(txCtx).Create(&TestModel{Name: "outer"}).Error; err != nil {
+				return err
+			}
+			err := WithTransaction(txCtx, func(nestedCtx context.Context) error {
+				warn(nestedCtx)
+				return nil
+			})
+			if !errors.Is(err, ErrWarningLogged) {
+				t.Errorf("expected nested transaction to abort, got %v", err)
+			}
+			return nil
+		})
+		if !errors.Is(err, ErrWarningLogged) {
+			t.Fatalf("expected ErrWarningLogged, got %v", err)
+		}
+		if count("outer") != 0 {
+			t.Error("expected the enclosing transaction to roll back")
+		}
+	})
+
+	t.Run("WithDefer", func(t *testing.T) {
+		err := func() (err error) {
+			txCtx, cleanup := WithDefer(abortCtx)
+			defer cleanup(&err)
+			if err := Current(txCtx).Create(&TestModel{Name: "deferred"}).Error; err != nil {
+				return err
+			}
+			warn(txCtx)
+			return nil
+		}()
+		if !errors.Is(err, ErrWarningLogged) {
+			t.Fatalf("expected ErrWarningLogged, got %v", err)
+		}
+		if count("deferred") != 0 {
+			t.Error("expected the transaction to roll back")
+		}
+	})
+
+	t.Run("no warning", func(t *testing.T) {
+		err := WithTransaction(abortCtx, func(txCtx context.Context) error {
+			return Current(txCtx).Create(&TestModel{Name: "clean"}).Error
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if count("clean") != 1 {
+			t.Error("expected the transaction to commit")
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package stx
+
+import (
+	"context"
+	"time"
+)
+
+// detachedContext carries the values of its parent but not its deadline or
+// cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key any) any {
+	return c.parent.Value(key)
+}
+
+// WithTransactionAsync runs fn in a transaction in a new goroutine, like
+// WithTransaction, and returns a channel that delivers its result once the
+// transaction has committed or rolled back, and is then closed. The caller is
+// decoupled from commit latency but can still await the outcome:
+//
+//	done := stx.WithTransactionAsync(ctx, recordAudit)
+//	...
+//	if err := <-done; err != nil {
+//	    log.Printf("audit failed: %v", err)
+//	}
+//
+// The transaction runs on a context that keeps the values of ctx, such as
+// the request ID, but not its deadline or cancellation, so it is not aborted
+// when the request that started it finishes early. A DB bound to the request
+// context with WithContext still uses that context for its queries; pass a DB
+// bound to a background context instead. The transaction is always a
+// top-level one: when ctx is in a transaction, it does not run on a savepoint,
+// which could not be used concurrently with the enclosing transaction, and
+// commits independently of it.
+func WithTransactionAsync(ctx context.Context, fn func(context.Context) error) <-chan error {
+	done := make(chan error, 1)
+	if fn == nil {
+		done <- ErrNilFunc
+		close(done)
+		return done
+	}
+
+	bg := context.Context(detachedContext{parent: ctx})
+	go func() {
+		defer close(done)
+		if IsTx(bg) {
+			done <- Detached(bg, func(rootCtx context.Context) error {
+				return WithTransaction(rootCtx, fn)
+			})
+			return
+		}
+		done <- WithTransaction(bg, fn)
+	}()
+	return done
+}
@@ -0,0 +1,77 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWithTransactionAsync(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	count := func(name string) int64 {
+		var n int64
+		db.Model(&TestModel{}).Where("name = ?", name).Count(&n)
+		return n
+	}
+
+	t.Run("success", func(t *testing.T) {
+		called := false
+		done := WithTransactionAsync(ctx, func(txCtx context.Context) error {
+			OnSuccess(txCtx, func() { called = true })
+			return Current(txCtx).Create(&TestModel{Name: "async"}).Error
+		})
+		if err := <-done; err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if _, open := <-done; open {
+			t.Error("expected the channel to be closed")
+		}
+		if !called || count("async") != 1 {
+			t.Error("expected the transaction to commit and run its callbacks")
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		errFailed := errors.New("failed")
+		done := WithTransactionAsync(ctx, func(txCtx context.Context) error {
+			if err := Current(txCtx).Create(&TestModel{Name: "async-failed"}).Error; err != nil {
+				return err
+			}
+			return errFailed
+		})
+		if err := <-done; !errors.Is(err, errFailed) {
+			t.Fatalf("expected errFailed, got %v", err)
+		}
+		if count("async-failed") != 0 {
+			t.Error("expected the transaction to roll back")
+		}
+	})
+
+	t.Run("outlives the request context", func(t *testing.T) {
+		reqCtx, cancel := context.WithCancel(WithRequestID(ctx, "req-1"))
+		release := make(chan struct{})
+		done := WithTransactionAsync(reqCtx, func(txCtx context.Context) error {
+			<-release
+			if txCtx.Err() != nil {
+				t.Error("expected the transaction context not to be canceled")
+			}
+			if RequestID(txCtx) != "req-1" {
+				t.Error("expected the request ID to be kept")
+			}
+			return nil
+		})
+		cancel()
+		close(release)
+		if err := <-done; err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+	})
+
+	t.Run("nil function", func(t *testing.T) {
+		if err := <-WithTransactionAsync(ctx, nil); err != ErrNilFunc {
+			t.Errorf("expected ErrNilFunc, got %v", err)
+		}
+	})
+}
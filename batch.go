@@ -0,0 +1,81 @@
+package stx
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	batchHandlersMu sync.RWMutex
+	batchHandlers   = make(map[string]func(items []any))
+)
+
+// RegisterBatchHandler registers the handler that receives the items
+// accumulated under key with OnSuccessBatch. Registering a handler for a key
+// again replaces the previous one. Handlers should be registered during
+// initialization.
+func RegisterBatchHandler(key string, handler func(items []any)) {
+	batchHandlersMu.Lock()
+	defer batchHandlersMu.Unlock()
+
+	if handler == nil {
+		delete(batchHandlers, key)
+		return
+	}
+	batchHandlers[key] = handler
+}
+
+// OnSuccessBatch accumulates item under key for the transaction in ctx. Once
+// the transaction commits, the handler registered for key with
+// RegisterBatchHandler is called once with all accumulated items, in the order
+// they were added. This lets callbacks that share expensive setup, such as
+// flushing a message producer, run once per transaction instead of once per
+// item. Items are discarded if the transaction rolls back or no handler is
+// registered. Outside a transaction the handler is called immediately with
+// just item.
+func OnSuccessBatch(ctx context.Context, key string, item any) {
+	if ctx == nil || callbacksSuppressed(ctx) {
+		return
+	}
+
+	stx := fromContext(ctx)
+	if stx == nil || !IsTx(ctx) {
+		runBatchHandler(key, []any{item})
+		return
+	}
+	if stx.resolver != nil {
+		// The flush would never run for a foreign transaction, see OnSuccess
+		return
+	}
+
+	stx.mu.Lock()
+	if stx.batches == nil {
+		stx.batches = make(map[string][]any)
+	}
+	_, pending := stx.batches[key]
+	stx.batches[key] = append(stx.batches[key], item)
+	stx.mu.Unlock()
+
+	// The first item for a key schedules the flush of the whole batch
+	if !pending {
+		OnSuccess(ctx, func() {
+			stx.mu.Lock()
+			items := stx.batches[key]
+			delete(stx.batches, key)
+			stx.mu.Unlock()
+
+			runBatchHandler(key, items)
+		})
+	}
+}
+
+// runBatchHandler calls the handler registered for key, if any
+func runBatchHandler(key string, items []any) {
+	batchHandlersMu.RLock()
+	handler := batchHandlers[key]
+	batchHandlersMu.RUnlock()
+
+	if handler != nil && len(items) > 0 {
+		handler(items)
+	}
+}
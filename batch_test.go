@@ -0,0 +1,95 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestOnSuccessBatch(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	var calls [][]any
+	RegisterBatchHandler("test-batch", func(items []any) {
+		calls = append(calls, items)
+	})
+	defer RegisterBatchHandler("test-batch", nil)
+
+	t.Run("one call per transaction", func(t *testing.T) {
+		calls = nil
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			OnSuccessBatch(txCtx, "test-batch", 1)
+			OnSuccessBatch(txCtx, "test-batch", 2)
+			OnSuccessBatch(txCtx, "test-batch", 3)
+			// Items for keys without a handler are dropped
+			OnSuccessBatch(txCtx, "unhandled", 4)
+
+			if len(calls) != 0 {
+				t.Error("expected handler to wait for the commit")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		if len(calls) != 1 {
+			t.Fatalf("expected handler to be called once, got %d calls", len(calls))
+		}
+		if items := calls[0]; len(items) != 3 || items[0] != 1 || items[1] != 2 || items[2] != 3 {
+			t.Errorf("expected all three items in order, got %v", items)
+		}
+	})
+
+	t.Run("discarded on rollback", func(t *testing.T) {
+		calls = nil
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			OnSuccessBatch(txCtx, "test-batch", 1)
+			return errors.New("rollback")
+		})
+		if err == nil {
+			t.Fatal("expected transaction to fail")
+		}
+		if len(calls) != 0 {
+			t.Errorf("expected no handler calls, got %v", calls)
+		}
+	})
+
+	t.Run("restored by SnapshotCallbacks", func(t *testing.T) {
+		calls = nil
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			// A key first used after the snapshot loses its flush on
+			// restore; adding to it again must schedule a new one
+			restore := SnapshotCallbacks(txCtx)
+			OnSuccessBatch(txCtx, "test-batch", "discarded")
+			restore()
+			OnSuccessBatch(txCtx, "test-batch", 1)
+
+			// A key used before the snapshot keeps its earlier items
+			restore = SnapshotCallbacks(txCtx)
+			OnSuccessBatch(txCtx, "test-batch", "discarded")
+			restore()
+			OnSuccessBatch(txCtx, "test-batch", 2)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		if len(calls) != 1 {
+			t.Fatalf("expected handler to be called once, got %d calls", len(calls))
+		}
+		if items := calls[0]; len(items) != 2 || items[0] != 1 || items[1] != 2 {
+			t.Errorf("expected only the items added outside restored branches, got %v", items)
+		}
+	})
+
+	t.Run("outside a transaction", func(t *testing.T) {
+		calls = nil
+		OnSuccessBatch(ctx, "test-batch", 1)
+		if len(calls) != 1 || len(calls[0]) != 1 {
+			t.Errorf("expected immediate call with one item, got %v", calls)
+		}
+	})
+}
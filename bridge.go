@@ -0,0 +1,47 @@
+package stx
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Bridge returns a context on which stx resolves its database with resolver,
+// for interoperating with libraries that store their own transaction in the
+// context. resolver is called on every use with the current context and
+// returns a GORM handle on the foreign transaction, or the root DB when there
+// is none:
+//
+//	ctx = stx.Bridge(ctx, func(ctx context.Context) *gorm.DB {
+//	    if tx, ok := otherlib.TxFrom(ctx); ok { // a *sql.Tx
+//	        session := db.Session(&gorm.Session{})
+//	        session.Statement.ConnPool = tx
+//	        return session
+//	    }
+//	    return db
+//	})
+//
+// Like with Adopt, a foreign transaction is not owned by stx: Current, IsTx
+// and nested transactions, which run on savepoints of it, work as usual, but
+// Commit and Rollback leave it to the library that started it, and OnSuccess
+// callbacks registered directly on the bridged context are dropped. The
+// bridged context cannot tell one foreign transaction from the next, so
+// helpers keeping per-transaction state, such as WriteOnce, NextSeq, Tag,
+// Prepare and Watch, treat it as outside a transaction. Transactions that
+// stx starts on top of the foreign one support them as usual.
+func Bridge(ctx context.Context, resolver func(ctx context.Context) *gorm.DB) context.Context {
+	return context.WithValue(ctx, txContextKey, &STX{resolver: resolver})
+}
+
+// txState returns the STX holding the state of the transaction in ctx, or
+// nil outside a transaction. It is nil for foreign transactions resolved
+// through Bridge as well: every foreign transaction behind a bridged context
+// shares its STX, so state kept there would leak from one transaction to the
+// next.
+func txState(ctx context.Context) *STX {
+	stx := fromContext(ctx)
+	if stx == nil || stx.resolver != nil || !IsTx(ctx) {
+		return nil
+	}
+	return stx
+}
@@ -0,0 +1,138 @@
+package stx
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// foreignTxKey is where a fake transaction manager stores its transaction
+type foreignTxKey struct{}
+
+func TestBridge(t *testing.T) {
+	db := setupTestDB(t)
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql.DB: %v", err)
+	}
+
+	resolver := func(ctx context.Context) *gorm.DB {
+		if tx, ok := ctx.Value(foreignTxKey{}).(*sql.Tx); ok {
+			session := db.Session(&gorm.Session{})
+			session.Statement.ConnPool = tx
+			return session
+		}
+		return db
+	}
+	ctx := Bridge(context.Background(), resolver)
+
+	count := func(name string) int64 {
+		var n int64
+		db.Model(&TestModel{}).Where("name = ?", name).Count(&n)
+		return n
+	}
+
+	t.Run("without foreign transaction", func(t *testing.T) {
+		if IsTx(ctx) {
+			t.Error("expected no transaction")
+		}
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			return Current(txCtx).Create(&TestModel{Name: "own"}).Error
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if count("own") != 1 {
+			t.Error("expected stx's own transaction to commit")
+		}
+	})
+
+	t.Run("layers on foreign transaction", func(t *testing.T) {
+		tx, err := sqlDB.Begin()
+		if err != nil {
+			t.Fatalf("failed to begin foreign transaction: %v", err)
+		}
+		foreignCtx := context.WithValue(ctx, foreignTxKey{}, tx)
+
+		if !IsTx(foreignCtx) || OwnsTransaction(foreignCtx) {
+			t.Fatal("expected the foreign transaction to be visible but not owned")
+		}
+		err = WithTransaction(foreignCtx, func(txCtx context.Context) error {
+			return Current(txCtx).Create(&TestModel{Name: "bridged"}).Error
+		})
+		if err != nil {
+			t.Fatalf("nested transaction failed: %v", err)
+		}
+		if err := Commit(foreignCtx); err != nil {
+			t.Fatalf("commit failed: %v", err)
+		}
+
+		// Commit left the transaction to its owner
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("foreign rollback failed: %v", err)
+		}
+		if count("bridged") != 0 {
+			t.Error("expected the work to follow the foreign transaction's rollback")
+		}
+	})
+}
+
+func TestBridgeDoesNotShareStateAcrossForeignTransactions(t *testing.T) {
+	db := setupTestDB(t)
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql.DB: %v", err)
+	}
+
+	ctx := Bridge(context.Background(), func(ctx context.Context) *gorm.DB {
+		if tx, ok := ctx.Value(foreignTxKey{}).(*sql.Tx); ok {
+			session := db.Session(&gorm.Session{})
+			session.Statement.ConnPool = tx
+			return session
+		}
+		return db
+	})
+
+	writes := 0
+	for i := 0; i < 2; i++ {
+		tx, err := sqlDB.Begin()
+		if err != nil {
+			t.Fatalf("failed to begin foreign transaction: %v", err)
+		}
+		foreignCtx := context.WithValue(ctx, foreignTxKey{}, tx)
+
+		if err := WriteOnce(foreignCtx, "k", func() error { writes++; return nil }); err != nil {
+			t.Fatalf("WriteOnce failed: %v", err)
+		}
+		if n := NextSeq(foreignCtx, "lines"); n != 0 {
+			t.Errorf("expected no sequence in a foreign transaction, got %d", n)
+		}
+		Tag(foreignCtx, "foreign")
+		OnSuccess(foreignCtx, func() { t.Error("expected the callback to be dropped") })
+		OnSuccessBatch(foreignCtx, "bridged", i)
+		if _, open := <-Watch(foreignCtx); open {
+			t.Error("expected Watch to return a closed channel")
+		}
+
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("foreign commit failed: %v", err)
+		}
+	}
+
+	if writes != 2 {
+		t.Errorf("expected WriteOnce to run once per foreign transaction, got %d runs", writes)
+	}
+	if tags := Tags(ctx); tags != nil {
+		t.Errorf("expected no tags on the bridged context, got %v", tags)
+	}
+
+	shared := fromContext(ctx)
+	shared.mu.RLock()
+	defer shared.mu.RUnlock()
+	if len(shared.callbacks) != 0 || len(shared.batches) != 0 || len(shared.writes) != 0 || len(shared.tags) != 0 {
+		t.Errorf("expected no state on the shared bridged STX, got %d callbacks, %d batches, %d writes, %d tags",
+			len(shared.callbacks), len(shared.batches), len(shared.writes), len(shared.tags))
+	}
+}
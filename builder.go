@@ -0,0 +1,123 @@
+package stx
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TxBuilder configures a transaction fluently and runs it with one of its
+// terminating methods. It is a thin layer over WithTransaction and WithDefer:
+//
+//	err := stx.Tx(ctx).Isolation(sql.LevelSerializable).ReadOnly().Timeout(time.Second).Run(fn)
+//
+// A TxBuilder is not safe for concurrent use.
+type TxBuilder struct {
+	ctx     context.Context
+	opts    *sql.TxOptions
+	timeout time.Duration
+}
+
+// Tx starts building a transaction on the database in ctx
+func Tx(ctx context.Context) *TxBuilder {
+	return &TxBuilder{ctx: ctx}
+}
+
+// Isolation sets the isolation level the transaction begins with
+func (b *TxBuilder) Isolation(level sql.IsolationLevel) *TxBuilder {
+	b.txOptions().Isolation = level
+	return b
+}
+
+// ReadOnly begins the transaction in read-only mode
+func (b *TxBuilder) ReadOnly() *TxBuilder {
+	b.txOptions().ReadOnly = true
+	return b
+}
+
+// Timeout bounds the transaction with a context deadline of d. Queries
+// issued through Current after the deadline fail with the context's error.
+func (b *TxBuilder) Timeout(d time.Duration) *TxBuilder {
+	b.timeout = d
+	return b
+}
+
+// Run runs fn in the transaction, like WithTransaction
+func (b *TxBuilder) Run(fn func(context.Context) error) error {
+	ctx, cancel := b.context()
+	defer cancel()
+
+	return WithTransaction(ctx, fn, b.optsSlice()...)
+}
+
+// Defer begins the transaction and returns its context and cleanup
+// function, like WithDefer
+func (b *TxBuilder) Defer() (context.Context, func(*error)) {
+	ctx, cancel := b.context()
+	txCtx, cleanup := WithDefer(ctx, b.optsSlice()...)
+
+	return txCtx, func(err *error) {
+		defer cancel()
+		cleanup(err)
+	}
+}
+
+// Result runs fn in the transaction configured by b and returns its value.
+// On error the transaction is rolled back and the zero value is returned.
+func Result[T any](b *TxBuilder, fn func(context.Context) (T, error)) (T, error) {
+	var result T
+	err := b.Run(func(ctx context.Context) error {
+		var err error
+		result, err = fn(ctx)
+		return err
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return result, nil
+}
+
+// txOptions returns the builder's transaction options, creating them on
+// first use
+func (b *TxBuilder) txOptions() *sql.TxOptions {
+	if b.opts == nil {
+		b.opts = &sql.TxOptions{}
+	}
+	return b.opts
+}
+
+// optsSlice returns the options in the form the low-level functions take
+func (b *TxBuilder) optsSlice() []*sql.TxOptions {
+	if b.opts == nil {
+		return nil
+	}
+	return []*sql.TxOptions{b.opts}
+}
+
+// context returns the context to run the transaction with, applying the
+// timeout
+func (b *TxBuilder) context() (context.Context, context.CancelFunc) {
+	if b.timeout <= 0 || b.ctx == nil {
+		return b.ctx, func() {}
+	}
+	return withTimeout(b.ctx, b.timeout)
+}
+
+// withTimeout returns a context with a deadline of d whose transactions bind
+// their DB to that deadline
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, d)
+
+	settings := settingsFromContext(ctx)
+	session := gorm.Session{}
+	if settings.Session != nil {
+		session = *settings.Session
+	}
+	session.Context = ctx
+	settings.Session = &session
+
+	return WithSettings(ctx, settings), cancel
+}
@@ -0,0 +1,120 @@
+package stx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTxBuilder(t *testing.T) {
+	db, pool := setupPoolTestDB(t)
+	ctx := New(context.Background(), db)
+
+	t.Run("Run matches WithTransaction options", func(t *testing.T) {
+		fn := func(txCtx context.Context) error {
+			model := TestModel{Name: "builder-run"}
+			return Current(txCtx).Create(&model).Error
+		}
+
+		if err := WithTransaction(ctx, fn, &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true}); err != nil {
+			t.Fatalf("low-level transaction failed: %v", err)
+		}
+		expected := *pool.lastOpts()
+
+		if err := Tx(ctx).Isolation(sql.LevelSerializable).ReadOnly().Run(fn); err != nil {
+			t.Fatalf("builder transaction failed: %v", err)
+		}
+		if got := *pool.lastOpts(); got != expected {
+			t.Errorf("expected options %+v, got %+v", expected, got)
+		}
+
+		var count int64
+		db.Model(&TestModel{}).Where("name = ?", "builder-run").Count(&count)
+		if count != 2 {
+			t.Errorf("expected 2 records, got %d", count)
+		}
+	})
+
+	t.Run("Run rolls back on error", func(t *testing.T) {
+		testErr := errors.New("test error")
+		err := Tx(ctx).Run(func(txCtx context.Context) error {
+			model := TestModel{Name: "builder-rollback"}
+			if err := Current(txCtx).Create(&model).Error; err != nil {
+				return err
+			}
+			return testErr
+		})
+		if err != testErr {
+			t.Fatalf("expected test error, got: %v", err)
+		}
+
+		var count int64
+		db.Model(&TestModel{}).Where("name = ?", "builder-rollback").Count(&count)
+		if count != 0 {
+			t.Errorf("expected 0 records after rollback, got %d", count)
+		}
+	})
+
+	t.Run("Timeout aborts queries after the deadline", func(t *testing.T) {
+		err := Tx(ctx).Timeout(20 * time.Millisecond).Run(func(txCtx context.Context) error {
+			time.Sleep(50 * time.Millisecond)
+			model := TestModel{Name: "builder-timeout"}
+			return Current(txCtx).Create(&model).Error
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline exceeded, got: %v", err)
+		}
+	})
+
+	t.Run("Defer matches WithDefer", func(t *testing.T) {
+		var callbackExecuted bool
+		err := func() (err error) {
+			txCtx, cleanup := Tx(ctx).Isolation(sql.LevelReadCommitted).Defer()
+			defer cleanup(&err)
+
+			OnSuccess(txCtx, func() {
+				callbackExecuted = true
+			})
+			model := TestModel{Name: "builder-defer"}
+			return Current(txCtx).Create(&model).Error
+		}()
+		if err != nil {
+			t.Fatalf("builder transaction failed: %v", err)
+		}
+		if opts := pool.lastOpts(); opts == nil || opts.Isolation != sql.LevelReadCommitted {
+			t.Errorf("expected read committed isolation, got %+v", opts)
+		}
+		if !callbackExecuted {
+			t.Error("expected callback to be executed after commit")
+		}
+	})
+
+	t.Run("Result returns value on commit", func(t *testing.T) {
+		id, err := Result(Tx(ctx), func(txCtx context.Context) (uint, error) {
+			model := TestModel{Name: "builder-result"}
+			err := Current(txCtx).Create(&model).Error
+			return model.ID, err
+		})
+		if err != nil {
+			t.Fatalf("builder transaction failed: %v", err)
+		}
+		if id == 0 {
+			t.Error("expected created ID to be returned")
+		}
+	})
+
+	t.Run("Result returns zero value on error", func(t *testing.T) {
+		testErr := errors.New("test error")
+		id, err := Result(Tx(ctx), func(txCtx context.Context) (uint, error) {
+			return 42, testErr
+		})
+		if err != testErr {
+			t.Fatalf("expected test error, got: %v", err)
+		}
+		if id != 0 {
+			t.Errorf("expected zero value on error, got %d", id)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package stx
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// callbackTimeout is the time in nanoseconds an OnSuccess callback may
+	// run before it is reported as overrunning, or 0 if disabled
+	callbackTimeout int64
+
+	callbackOverrunMu      sync.RWMutex
+	callbackOverrunHandler func(timeout time.Duration)
+)
+
+// SetCallbackTimeout makes stx watch each OnSuccess callback as it runs after
+// a commit. A callback still running after d is reported to the handler set
+// with SetCallbackOverrunHandler, or logged as a warning through the DB's GORM
+// logger if there is none. The callback is not interrupted: it still runs to
+// completion, stx only observes the overrun. A zero or negative d disables the
+// watchdog, which is the default.
+func SetCallbackTimeout(d time.Duration) {
+	atomic.StoreInt64(&callbackTimeout, int64(d))
+}
+
+// SetCallbackOverrunHandler sets the function called when an OnSuccess
+// callback runs longer than the timeout set with SetCallbackTimeout. It is
+// called from a separate goroutine while the callback is still running, with
+// the configured timeout. Passing nil restores the default warning.
+func SetCallbackOverrunHandler(handler func(timeout time.Duration)) {
+	callbackOverrunMu.Lock()
+	defer callbackOverrunMu.Unlock()
+	callbackOverrunHandler = handler
+}
+
+// runWatched runs callback, reporting it if it exceeds the callback timeout
+func (stx *STX) runWatched(callback func()) {
+	d := time.Duration(atomic.LoadInt64(&callbackTimeout))
+	if d <= 0 {
+		callback()
+		return
+	}
+
+	watchdog := time.AfterFunc(d, func() { stx.reportCallbackOverrun(d) })
+	defer watchdog.Stop()
+	callback()
+}
+
+// reportCallbackOverrun notifies the overrun handler, falling back to a
+// warning through the DB's GORM logger
+func (stx *STX) reportCallbackOverrun(d time.Duration) {
+	callbackOverrunMu.RLock()
+	handler := callbackOverrunHandler
+	callbackOverrunMu.RUnlock()
+
+	if handler != nil {
+		handler(d)
+		return
+	}
+	if stx.db != nil && stx.db.Logger != nil {
+		stx.db.Logger.Warn(context.Background(), "stx: OnSuccess callback still running after %s", d)
+	}
+}
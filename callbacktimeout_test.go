@@ -0,0 +1,48 @@
+package stx
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSetCallbackTimeout(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	overruns := make(chan time.Duration, 2)
+	SetCallbackTimeout(10 * time.Millisecond)
+	SetCallbackOverrunHandler(func(timeout time.Duration) { overruns <- timeout })
+	defer SetCallbackTimeout(0)
+	defer SetCallbackOverrunHandler(nil)
+
+	completed := false
+	err := WithTransaction(ctx, func(txCtx context.Context) error {
+		OnSuccess(txCtx, func() {})
+		OnSuccess(txCtx, func() {
+			time.Sleep(50 * time.Millisecond)
+			completed = true
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+
+	if !completed {
+		t.Error("expected the slow callback to run to completion")
+	}
+	select {
+	case d := <-overruns:
+		if d != 10*time.Millisecond {
+			t.Errorf("expected the handler to receive the timeout, got %s", d)
+		}
+	default:
+		t.Fatal("expected the overrun handler to fire")
+	}
+	select {
+	case <-overruns:
+		t.Error("expected the fast callback not to be reported")
+	default:
+	}
+}
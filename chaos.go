@@ -0,0 +1,74 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+)
+
+const chaosContextKey contextKey = "stx:chaos"
+
+var (
+	// ErrChaosRollback is returned when WithChaos turned a commit into a
+	// rollback.
+	ErrChaosRollback = errors.New("stx: chaos: commit turned into rollback")
+	// ErrChaosCommitFailed is returned when WithChaos injected a commit
+	// error. The transaction was committed nevertheless.
+	ErrChaosCommitFailed = errors.New("stx: chaos: injected commit error")
+)
+
+// ChaosConfig configures the failures injected by WithChaos. Probabilities
+// range from 0 to 1 and are drawn once per commit.
+type ChaosConfig struct {
+	// RollbackProbability is the probability that a commit is turned into a
+	// rollback, reported as ErrChaosRollback.
+	RollbackProbability float64
+	// CommitErrorProbability is the probability that a commit succeeds but
+	// reports ErrChaosCommitFailed, like a commit whose acknowledgment was
+	// lost on the way back from the database.
+	CommitErrorProbability float64
+	// Rand returns a number in [0, 1) and defaults to math/rand.Float64. Set
+	// it to make the failures deterministic.
+	Rand func() float64
+}
+
+// chaosOutcome is what WithChaos does to a commit
+type chaosOutcome int
+
+const (
+	chaosNone chaosOutcome = iota
+	chaosRollback
+	chaosCommitError
+)
+
+// WithChaos returns a context whose transactions fail their commits at
+// random, according to cfg, to verify in resilience tests that callers
+// handle commit failures. A failed commit returns ErrChaosRollback or
+// ErrChaosCommitFailed from WithTransaction, Commit and the WithDefer
+// cleanup, and OnSuccess callbacks do not run. Chaos only applies to
+// contexts explicitly derived from WithChaos and is never enabled by
+// default; do not use it in production code paths.
+func WithChaos(ctx context.Context, cfg ChaosConfig) context.Context {
+	return context.WithValue(ctx, chaosContextKey, &cfg)
+}
+
+// chaosFor draws the outcome of a commit in ctx
+func chaosFor(ctx context.Context) chaosOutcome {
+	cfg, _ := ctx.Value(chaosContextKey).(*ChaosConfig)
+	if cfg == nil {
+		return chaosNone
+	}
+
+	draw := rand.Float64
+	if cfg.Rand != nil {
+		draw = cfg.Rand
+	}
+	r := draw()
+	switch {
+	case r < cfg.RollbackProbability:
+		return chaosRollback
+	case r < cfg.RollbackProbability+cfg.CommitErrorProbability:
+		return chaosCommitError
+	}
+	return chaosNone
+}
@@ -0,0 +1,101 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWithChaos(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	count := func(name string) int64 {
+		var n int64
+		db.Model(&TestModel{}).Where("name = ?", name).Count(&n)
+		return n
+	}
+	run := func(ctx context.Context, name string) (bool, error) {
+		called := false
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			OnSuccess(txCtx, func() { called = true })
+			return Current(txCtx).Create(&TestModel{Name: name}).Error
+		})
+		return called, err
+	}
+
+	t.Run("off by default", func(t *testing.T) {
+		called, err := run(ctx, "calm")
+		if err != nil || !called || count("calm") != 1 {
+			t.Errorf("expected a normal commit, got %v", err)
+		}
+	})
+
+	t.Run("commit turned into rollback", func(t *testing.T) {
+		chaosCtx := WithChaos(ctx, ChaosConfig{RollbackProbability: 1.0})
+
+		called, err := run(chaosCtx, "chaos-rollback")
+		if !errors.Is(err, ErrChaosRollback) {
+			t.Fatalf("expected ErrChaosRollback, got %v", err)
+		}
+		if called {
+			t.Error("expected callbacks not to fire")
+		}
+		if count("chaos-rollback") != 0 {
+			t.Error("expected the transaction to roll back")
+		}
+
+		txCtx := Begin(chaosCtx)
+		OnSuccess(txCtx, func() { called = true })
+		if err := CommitIf(txCtx, func() bool { return true }); !errors.Is(err, ErrChaosRollback) {
+			t.Errorf("expected CommitIf to fail with ErrChaosRollback, got %v", err)
+		}
+		if called {
+			t.Error("expected callbacks not to fire")
+		}
+	})
+
+	t.Run("injected commit error", func(t *testing.T) {
+		chaosCtx := WithChaos(ctx, ChaosConfig{CommitErrorProbability: 1.0})
+
+		called, err := run(chaosCtx, "chaos-error")
+		if !errors.Is(err, ErrChaosCommitFailed) {
+			t.Fatalf("expected ErrChaosCommitFailed, got %v", err)
+		}
+		if called {
+			t.Error("expected callbacks not to fire")
+		}
+		if count("chaos-error") != 1 {
+			t.Error("expected the transaction to be committed despite the error")
+		}
+
+		err = func() (err error) {
+			txCtx, cleanup := WithDefer(chaosCtx)
+			defer cleanup(&err)
+			return Current(txCtx).Create(&TestModel{Name: "chaos-defer"}).Error
+		}()
+		if !errors.Is(err, ErrChaosCommitFailed) {
+			t.Errorf("expected the WithDefer cleanup to report ErrChaosCommitFailed, got %v", err)
+		}
+	})
+
+	t.Run("probability", func(t *testing.T) {
+		draws := []float64{0.1, 0.5, 0.9}
+		chaosCtx := WithChaos(ctx, ChaosConfig{
+			RollbackProbability:    0.3,
+			CommitErrorProbability: 0.3,
+			Rand: func() float64 {
+				r := draws[0]
+				draws = draws[1:]
+				return r
+			},
+		})
+
+		want := []error{ErrChaosRollback, ErrChaosCommitFailed, nil}
+		for i, expected := range want {
+			if _, err := run(chaosCtx, "drawn"); !errors.Is(err, expected) {
+				t.Errorf("draw %d: expected %v, got %v", i, expected, err)
+			}
+		}
+	})
+}
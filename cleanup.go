@@ -0,0 +1,67 @@
+package stx
+
+import "sync"
+
+// CleanupStack collects cleanup functions, such as those returned by
+// WithDefer, and runs them in reverse order from a single deferred call.
+// Code that opens several resources then needs one defer:
+//
+//	func importFile(ctx context.Context, path string) (err error) {
+//	    var stack stx.CleanupStack
+//	    defer stack.Run(&err)
+//
+//	    f, err := os.Open(path)
+//	    if err != nil {
+//	        return err
+//	    }
+//	    stack.Push(func(err *error) {
+//	        if closeErr := f.Close(); closeErr != nil && *err == nil {
+//	            *err = closeErr
+//	        }
+//	    })
+//
+//	    txCtx, cleanup := stx.WithDefer(ctx)
+//	    stack.Push(cleanup)
+//	    return load(txCtx, f)
+//	}
+//
+// The zero value is ready to use.
+type CleanupStack struct {
+	mu       sync.Mutex
+	cleanups []func(*error)
+}
+
+// Push adds cleanup to the stack. It runs before the cleanups pushed earlier.
+func (s *CleanupStack) Push(cleanup func(*error)) {
+	if cleanup == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.cleanups = append(s.cleanups, cleanup)
+	s.mu.Unlock()
+}
+
+// Run runs the pushed cleanups in reverse order and empties the stack. All
+// cleanups share err, so each sees the error left by the function and the
+// cleanups run before it, and can set it. Run must be deferred directly.
+// Cleanups called from Run cannot recover a panic themselves, so Run recovers
+// it and sets *err to the panic error first: WithDefer cleanups then roll
+// back instead of committing. A nil err is tolerated.
+func (s *CleanupStack) Run(err *error) {
+	if err == nil {
+		err = new(error)
+	}
+	if r := recover(); r != nil {
+		*err = panicError(r)
+	}
+
+	s.mu.Lock()
+	cleanups := s.cleanups
+	s.cleanups = nil
+	s.mu.Unlock()
+
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i](err)
+	}
+}
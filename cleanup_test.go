@@ -0,0 +1,104 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCleanupStack(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+	errClose := errors.New("close failed")
+
+	count := func(name string) int64 {
+		var n int64
+		db.Model(&TestModel{}).Where("name = ?", name).Count(&n)
+		return n
+	}
+
+	t.Run("reverse order", func(t *testing.T) {
+		var order []string
+		err := func() (err error) {
+			var stack CleanupStack
+			defer stack.Run(&err)
+
+			stack.Push(func(*error) { order = append(order, "first") })
+			stack.Push(func(*error) { order = append(order, "second") })
+			return nil
+		}()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(order, []string{"second", "first"}) {
+			t.Errorf("expected reverse order, got %v", order)
+		}
+	})
+
+	t.Run("error propagates", func(t *testing.T) {
+		var seen error
+		err := func() (err error) {
+			var stack CleanupStack
+			defer stack.Run(&err)
+
+			stack.Push(func(err *error) { seen = *err })
+			stack.Push(func(err *error) {
+				if *err == nil {
+					*err = errClose
+				}
+			})
+
+			txCtx, cleanup := WithDefer(ctx)
+			stack.Push(cleanup)
+			return Current(txCtx).Create(&TestModel{Name: "stacked"}).Error
+		}()
+		if !errors.Is(err, errClose) || !errors.Is(seen, errClose) {
+			t.Errorf("expected the close error to reach the caller and earlier cleanups, got %v and %v", err, seen)
+		}
+		if count("stacked") != 1 {
+			t.Error("expected the transaction to commit before the failing cleanup")
+		}
+	})
+
+	t.Run("function error rolls back", func(t *testing.T) {
+		errFailed := errors.New("failed")
+		err := func() (err error) {
+			var stack CleanupStack
+			defer stack.Run(&err)
+
+			txCtx, cleanup := WithDefer(ctx)
+			stack.Push(cleanup)
+			if err := Current(txCtx).Create(&TestModel{Name: "failed"}).Error; err != nil {
+				return err
+			}
+			return errFailed
+		}()
+		if !errors.Is(err, errFailed) {
+			t.Errorf("expected errFailed, got %v", err)
+		}
+		if count("failed") != 0 {
+			t.Error("expected the transaction to roll back")
+		}
+	})
+
+	t.Run("panic rolls back", func(t *testing.T) {
+		err := func() (err error) {
+			var stack CleanupStack
+			defer stack.Run(&err)
+
+			txCtx, cleanup := WithDefer(ctx)
+			stack.Push(cleanup)
+			if err := Current(txCtx).Create(&TestModel{Name: "panicked"}).Error; err != nil {
+				return err
+			}
+			panic("boom")
+		}()
+		if err == nil {
+			t.Error("expected the panic to be reported")
+		}
+		if count("panicked") != 0 {
+			t.Error("expected the transaction to roll back")
+		}
+	})
+}
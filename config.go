@@ -0,0 +1,85 @@
+package stx
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// defaultIsolation is the sql.IsolationLevel transactions begin with
+	// when no options are given
+	defaultIsolation int64
+	// defaultTimeout is the deadline in nanoseconds of transactions whose
+	// context has none, or 0 if disabled
+	defaultTimeout int64
+	// maxRetries is the number of retries WithOptimisticRetry makes when
+	// called without a maximum
+	maxRetries int64
+)
+
+// Config holds package-wide defaults, so applications that configure stx
+// centrally, for example from a JSON file, can set them in one call with
+// Configure instead of calling each setter.
+type Config struct {
+	// DefaultIsolation is the isolation level top-level transactions begin
+	// with when neither the call nor WithSettings passes TxOptions.
+	DefaultIsolation sql.IsolationLevel `json:"default_isolation"`
+	// DefaultTimeout bounds top-level WithTransaction and WithDefer
+	// transactions whose context has no deadline, like TxBuilder.Timeout.
+	DefaultTimeout time.Duration `json:"default_timeout"`
+	// MaxRetries is the number of retries WithOptimisticRetry makes after
+	// the first attempt when it is called with maxAttempts below 1.
+	MaxRetries int `json:"max_retries"`
+	// MaxLifetime is passed to SetMaxLifetime.
+	MaxLifetime time.Duration `json:"max_lifetime"`
+	// CallbackTimeout is passed to SetCallbackTimeout.
+	CallbackTimeout time.Duration `json:"callback_timeout"`
+	// NestingWarnThreshold is passed to SetNestingWarnThreshold.
+	NestingWarnThreshold int `json:"nesting_warn_threshold"`
+	// StrictDefer is passed to SetStrictDefer.
+	StrictDefer bool `json:"strict_defer"`
+	// DetectStaleContext is passed to SetDetectStaleContext.
+	DetectStaleContext bool `json:"detect_stale_context"`
+}
+
+// Configure replaces the package defaults with cfg. Zero fields restore the
+// built-in default of their setting, so Configure(Config{}) resets
+// everything. Per-call options, such as TxOptions passed to WithTransaction
+// or a context deadline, take precedence over the defaults. Like the
+// individual setters, Configure affects transactions started after the call
+// and is meant to be called during initialization.
+func Configure(cfg Config) {
+	atomic.StoreInt64(&defaultIsolation, int64(cfg.DefaultIsolation))
+	atomic.StoreInt64(&defaultTimeout, int64(cfg.DefaultTimeout))
+	atomic.StoreInt64(&maxRetries, int64(cfg.MaxRetries))
+	SetMaxLifetime(cfg.MaxLifetime)
+	SetCallbackTimeout(cfg.CallbackTimeout)
+	SetNestingWarnThreshold(cfg.NestingWarnThreshold)
+	SetStrictDefer(cfg.StrictDefer)
+	SetDetectStaleContext(cfg.DetectStaleContext)
+}
+
+// defaultTxOptions returns the options for a transaction begun without any,
+// or nil if no default isolation level is configured
+func defaultTxOptions() []*sql.TxOptions {
+	level := sql.IsolationLevel(atomic.LoadInt64(&defaultIsolation))
+	if level == sql.LevelDefault {
+		return nil
+	}
+	return []*sql.TxOptions{{Isolation: level}}
+}
+
+// withDefaultTimeout applies the default timeout to ctx if it has no
+// deadline yet
+func withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	d := time.Duration(atomic.LoadInt64(&defaultTimeout))
+	if d <= 0 || ctx == nil {
+		return ctx, func() {}
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return withTimeout(ctx, d)
+}
@@ -0,0 +1,77 @@
+package stx
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConfigure(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+	defer Configure(Config{})
+
+	var cfg Config
+	if err := json.Unmarshal([]byte(`{"default_isolation": 6, "default_timeout": 20000000, "max_retries": 2}`), &cfg); err != nil {
+		t.Fatalf("failed to decode config: %v", err)
+	}
+	Configure(cfg)
+
+	t.Run("default timeout", func(t *testing.T) {
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			if _, ok := txCtx.Deadline(); !ok {
+				t.Error("expected the transaction context to have a deadline")
+			}
+			time.Sleep(40 * time.Millisecond)
+			var count int64
+			return Current(txCtx).Model(&TestModel{}).Count(&count).Error
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the default timeout to abort the query, got %v", err)
+		}
+	})
+
+	t.Run("per-call deadline takes precedence", func(t *testing.T) {
+		callCtx, cancel := context.WithTimeout(ctx, time.Hour)
+		defer cancel()
+
+		err := WithTransaction(callCtx, func(txCtx context.Context) error {
+			if deadline, _ := txCtx.Deadline(); time.Until(deadline) < time.Minute {
+				t.Errorf("expected the caller's deadline to be kept, got %s", deadline)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+	})
+
+	t.Run("default isolation", func(t *testing.T) {
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			if level, ok := IsolationLevel(txCtx); !ok || level != sql.LevelSerializable {
+				t.Errorf("expected the default isolation level, got %v", level)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+	})
+
+	t.Run("max retries", func(t *testing.T) {
+		attempts := 0
+		err := WithOptimisticRetry(ctx, 0, func(context.Context) error {
+			attempts++
+			return ErrVersionConflict
+		})
+		if !errors.Is(err, ErrVersionConflict) {
+			t.Fatalf("expected ErrVersionConflict, got %v", err)
+		}
+		if attempts != 3 {
+			t.Errorf("expected 1 attempt plus 2 retries, got %d", attempts)
+		}
+	})
+}
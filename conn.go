@@ -0,0 +1,73 @@
+package stx
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"gorm.io/gorm"
+)
+
+const dedicatedConnContextKey contextKey = "stx:dedicated_conn"
+
+// WithDedicatedConn returns a context whose top-level transactions, started
+// with Begin, WithTransaction or WithDefer, run on a connection acquired just
+// for them. The connection is closed rather than returned to the pool when the
+// transaction ends, so session state set within the transaction, such as SET
+// or PRAGMA statements, cannot leak into later transactions. This trades a new
+// connection per transaction for isolation. Nested transactions keep using the
+// enclosing transaction's connection.
+func WithDedicatedConn(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dedicatedConnContextKey, true)
+}
+
+// acquireConn returns a handle on db bound to a dedicated connection from its
+// pool, and a function that closes the connection and discards it from the pool
+func acquireConn(ctx context.Context, db *gorm.DB) (*gorm.DB, func(), error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	connDB := db.Session(&gorm.Session{NewDB: true, Context: db.Statement.Context})
+	connDB.Statement.ConnPool = conn
+
+	discard := func() {
+		// Returning ErrBadConn from Raw makes database/sql close the
+		// connection instead of putting it back into the pool
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		conn.Close()
+	}
+	return connDB, discard, nil
+}
+
+// withConn calls fn with db, or with db bound to a dedicated connection if
+// ctx asks for one and is not already in a transaction
+func withConn(ctx context.Context, db *gorm.DB, fn func(db *gorm.DB) error) error {
+	if dedicated, _ := ctx.Value(dedicatedConnContextKey).(bool); !dedicated || IsTx(ctx) {
+		return fn(db)
+	}
+
+	connDB, discard, err := acquireConn(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer discard()
+	return fn(connDB)
+}
+
+// discardConn releases the transaction's dedicated connection, if any
+func (stx *STX) discardConn() {
+	stx.mu.Lock()
+	discard := stx.connDiscard
+	stx.connDiscard = nil
+	stx.mu.Unlock()
+
+	if discard != nil {
+		discard()
+	}
+}
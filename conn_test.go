@@ -0,0 +1,78 @@
+package stx
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestWithDedicatedConn(t *testing.T) {
+	db := setupTestDB(t)
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql.DB: %v", err)
+	}
+	// With a single connection, pooled transactions always share it
+	sqlDB.SetMaxOpenConns(1)
+
+	busyTimeout := func(db *gorm.DB) int {
+		var timeout int
+		if err := db.Raw("PRAGMA busy_timeout").Scan(&timeout).Error; err != nil {
+			t.Fatalf("failed to read busy_timeout: %v", err)
+		}
+		return timeout
+	}
+	setBusyTimeout := func(txCtx context.Context) error {
+		return Current(txCtx).Exec("PRAGMA busy_timeout = 1234").Error
+	}
+
+	defaultTimeout := busyTimeout(db)
+	if defaultTimeout == 1234 {
+		t.Fatal("test value must differ from the default busy_timeout")
+	}
+
+	t.Run("WithTransaction", func(t *testing.T) {
+		ctx := WithDedicatedConn(New(context.Background(), db))
+		if err := WithTransaction(ctx, setBusyTimeout); err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			if got := busyTimeout(Current(txCtx)); got != defaultTimeout {
+				t.Errorf("expected session state not to leak, got busy_timeout %d", got)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+	})
+
+	t.Run("Begin", func(t *testing.T) {
+		ctx := WithDedicatedConn(New(context.Background(), db))
+		txCtx := Begin(ctx)
+		if err := setBusyTimeout(txCtx); err != nil {
+			t.Fatalf("failed to set busy_timeout: %v", err)
+		}
+		if err := Rollback(txCtx); err != nil {
+			t.Fatalf("rollback failed: %v", err)
+		}
+
+		txCtx = Begin(ctx)
+		defer Commit(txCtx)
+		if got := busyTimeout(Current(txCtx)); got != defaultTimeout {
+			t.Errorf("expected session state not to leak, got busy_timeout %d", got)
+		}
+	})
+
+	t.Run("pooled connection leaks", func(t *testing.T) {
+		ctx := New(context.Background(), db)
+		if err := WithTransaction(ctx, setBusyTimeout); err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if got := busyTimeout(db); got != 1234 {
+			t.Errorf("expected pooled connection to keep session state, got busy_timeout %d", got)
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package stx
+
+import (
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// PostgreSQL SQLSTATE codes for integrity constraint violations
+const (
+	sqlStateUniqueViolation     = "23505"
+	sqlStateForeignKeyViolation = "23503"
+)
+
+// IsUniqueViolation reports whether err is caused by a unique or primary key
+// constraint violation. It recognizes gorm.ErrDuplicatedKey, which GORM
+// returns with TranslateError enabled, PostgreSQL errors from pgx and lib/pq,
+// and SQLite errors, so callers can map them to user-facing messages without
+// driver-specific code.
+func IsUniqueViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return true
+	}
+	return hasSQLState(err, sqlStateUniqueViolation) ||
+		strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// IsForeignKeyViolation reports whether err is caused by a foreign key
+// constraint violation. Like IsUniqueViolation, it recognizes
+// gorm.ErrForeignKeyViolated, PostgreSQL errors and SQLite errors. SQLite only
+// enforces foreign keys with PRAGMA foreign_keys = ON.
+func IsForeignKeyViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, gorm.ErrForeignKeyViolated) {
+		return true
+	}
+	return hasSQLState(err, sqlStateForeignKeyViolation) ||
+		strings.Contains(err.Error(), "FOREIGN KEY constraint failed")
+}
+
+// hasSQLState reports whether err wraps a driver error with the given
+// SQLSTATE code, as returned by the PostgreSQL drivers
+func hasSQLState(err error, code string) bool {
+	var stateErr interface{ SQLState() string }
+	return errors.As(err, &stateErr) && stateErr.SQLState() == code
+}
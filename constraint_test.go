@@ -0,0 +1,92 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// sqlStateError mimics the error types of the PostgreSQL drivers
+type sqlStateError struct {
+	code string
+}
+
+func (e *sqlStateError) Error() string    { return "pq: constraint violated" }
+func (e *sqlStateError) SQLState() string { return e.code }
+
+func TestConstraintViolations(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	if err := db.Create(&TestModel{ID: 1, Name: "existing"}).Error; err != nil {
+		t.Fatalf("failed to create record: %v", err)
+	}
+
+	t.Run("SQLite unique violation", func(t *testing.T) {
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			return Current(txCtx).Create(&TestModel{ID: 1, Name: "duplicate"}).Error
+		})
+		if !IsUniqueViolation(err) {
+			t.Errorf("expected unique violation, got %v", err)
+		}
+		if IsForeignKeyViolation(err) {
+			t.Errorf("expected no foreign key violation, got %v", err)
+		}
+	})
+
+	t.Run("SQLite foreign key violation", func(t *testing.T) {
+		fkDB, err := gorm.Open(sqlite.Open(testDSN()+"&_foreign_keys=1"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+		if err != nil {
+			t.Fatalf("failed to connect database: %v", err)
+		}
+
+		err = WithTransaction(New(context.Background(), fkDB), func(txCtx context.Context) error {
+			tx := Current(txCtx)
+			if err := tx.Exec("CREATE TABLE fk_parents (id INTEGER PRIMARY KEY)").Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("CREATE TABLE fk_children (id INTEGER PRIMARY KEY, parent_id INTEGER REFERENCES fk_parents(id))").Error; err != nil {
+				return err
+			}
+			return tx.Exec("INSERT INTO fk_children (id, parent_id) VALUES (1, 999)").Error
+		})
+		if !IsForeignKeyViolation(err) {
+			t.Errorf("expected foreign key violation, got %v", err)
+		}
+		if IsUniqueViolation(err) {
+			t.Errorf("expected no unique violation, got %v", err)
+		}
+	})
+
+	t.Run("other errors", func(t *testing.T) {
+		err := Current(ctx).Exec("SELECT * FROM missing_table").Error
+		for _, err := range []error{nil, err, errors.New("failed"), gorm.ErrRecordNotFound} {
+			if IsUniqueViolation(err) || IsForeignKeyViolation(err) {
+				t.Errorf("expected %v not to be a constraint violation", err)
+			}
+		}
+	})
+
+	t.Run("translated and PostgreSQL errors", func(t *testing.T) {
+		if !IsUniqueViolation(fmt.Errorf("create: %w", gorm.ErrDuplicatedKey)) {
+			t.Error("expected ErrDuplicatedKey to be a unique violation")
+		}
+		if !IsForeignKeyViolation(gorm.ErrForeignKeyViolated) {
+			t.Error("expected ErrForeignKeyViolated to be a foreign key violation")
+		}
+		if !IsUniqueViolation(fmt.Errorf("create: %w", &sqlStateError{code: "23505"})) {
+			t.Error("expected SQLSTATE 23505 to be a unique violation")
+		}
+		if !IsForeignKeyViolation(&sqlStateError{code: "23503"}) {
+			t.Error("expected SQLSTATE 23503 to be a foreign key violation")
+		}
+		if IsUniqueViolation(&sqlStateError{code: "23503"}) {
+			t.Error("expected SQLSTATE 23503 not to be a unique violation")
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package stx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Decision tells WithDecision how to finish a transaction
+type Decision int
+
+const (
+	// DecisionCommit commits the transaction, even if fn returned an error,
+	// and returns fn's error
+	DecisionCommit Decision = iota
+	// DecisionRollback rolls the transaction back and returns fn's error
+	DecisionRollback
+	// DecisionRollbackAndReturnNil rolls the transaction back and returns nil
+	DecisionRollbackAndReturnNil
+)
+
+// errDecidedRollback makes WithTransaction roll back on behalf of WithDecision
+var errDecidedRollback = errors.New("stx: rollback decided")
+
+// WithDecision runs fn in a transaction like WithTransaction, but lets decide
+// choose the outcome based on fn's error instead of committing on nil and
+// rolling back otherwise. This generalizes RollbackIf: a flow can persist its
+// work while still reporting an error, or roll back a successful run. OnSuccess
+// callbacks run only when the transaction commits. Errors from committing are
+// returned as usual. A nil decide commits on nil and rolls back otherwise.
+// A nested WithDecision runs on a savepoint even under WithFlatNesting, so
+// that rollback decisions take effect.
+func WithDecision(ctx context.Context, fn func(context.Context) error, decide func(err error) Decision, opts ...*sql.TxOptions) error {
+	if fn == nil {
+		return ErrNilFunc
+	}
+	if decide == nil {
+		decide = func(err error) Decision {
+			if err != nil {
+				return DecisionRollback
+			}
+			return DecisionCommit
+		}
+	}
+
+	var fnErr error
+	var decision Decision
+	err := withTransaction(ctx, func(txCtx context.Context) error {
+		fnErr = fn(txCtx)
+		decision = decide(fnErr)
+		if decision != DecisionCommit {
+			return errDecidedRollback
+		}
+		return nil
+	}, false, opts...)
+
+	switch {
+	case err == errDecidedRollback && decision == DecisionRollbackAndReturnNil:
+		return nil
+	case err == errDecidedRollback:
+		return fnErr
+	case err != nil:
+		return err
+	}
+	return fnErr
+}
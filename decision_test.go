@@ -0,0 +1,70 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWithDecision(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+	testErr := errors.New("partial failure")
+
+	tests := []struct {
+		name       string
+		fnErr      error
+		decision   Decision
+		wantErr    error
+		wantRows   int64
+		wantCalled bool
+	}{
+		{"commit with error", testErr, DecisionCommit, testErr, 1, true},
+		{"commit without error", nil, DecisionCommit, nil, 1, true},
+		{"rollback with error", testErr, DecisionRollback, testErr, 0, false},
+		{"rollback without error", nil, DecisionRollback, nil, 0, false},
+		{"rollback and return nil", testErr, DecisionRollbackAndReturnNil, nil, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := "decision-" + tt.name
+			var called bool
+			var decided error
+
+			err := WithDecision(ctx, func(txCtx context.Context) error {
+				OnSuccess(txCtx, func() { called = true })
+				if err := Current(txCtx).Create(&TestModel{Name: name}).Error; err != nil {
+					return err
+				}
+				return tt.fnErr
+			}, func(err error) Decision {
+				decided = err
+				return tt.decision
+			})
+
+			if err != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if decided != tt.fnErr {
+				t.Errorf("expected decide to receive %v, got %v", tt.fnErr, decided)
+			}
+			if called != tt.wantCalled {
+				t.Errorf("expected callback called=%v, got %v", tt.wantCalled, called)
+			}
+
+			var count int64
+			db.Model(&TestModel{}).Where("name = ?", name).Count(&count)
+			if count != tt.wantRows {
+				t.Errorf("expected %d records, got %d", tt.wantRows, count)
+			}
+		})
+	}
+
+	t.Run("nil decide", func(t *testing.T) {
+		err := WithDecision(ctx, func(context.Context) error { return testErr }, nil)
+		if err != testErr {
+			t.Errorf("expected fn error, got %v", err)
+		}
+	})
+}
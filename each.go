@@ -0,0 +1,25 @@
+package stx
+
+import "context"
+
+// RunEach runs fn for every item, each in its own transaction, and returns
+// the per-item errors: errs[i] is the error of items[i], nil if its
+// transaction committed. A failing item only rolls back its own transaction
+// and the remaining items still run, which suits batch jobs over independent
+// units. When ctx is already in a transaction, each item runs on a savepoint
+// and only persists if that transaction commits.
+func RunEach[T any](ctx context.Context, items []T, fn func(ctx context.Context, item T) error) []error {
+	errs := make([]error, len(items))
+	for i, item := range items {
+		if fn == nil {
+			errs[i] = ErrNilFunc
+			continue
+		}
+
+		item := item
+		errs[i] = withTransaction(ctx, func(txCtx context.Context) error {
+			return fn(txCtx, item)
+		}, false)
+	}
+	return errs
+}
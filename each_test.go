@@ -0,0 +1,45 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunEach(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+	errBob := errors.New("bob failed")
+
+	var committed []string
+	errs := RunEach(ctx, []string{"alice", "bob", "carol"}, func(txCtx context.Context, name string) error {
+		if err := Current(txCtx).Create(&TestModel{Name: name}).Error; err != nil {
+			return err
+		}
+		if name == "bob" {
+			return errBob
+		}
+		OnSuccess(txCtx, func() { committed = append(committed, name) })
+		return nil
+	})
+
+	if len(errs) != 3 || errs[0] != nil || !errors.Is(errs[1], errBob) || errs[2] != nil {
+		t.Fatalf("expected only bob to fail, got %v", errs)
+	}
+	if len(committed) != 2 || committed[0] != "alice" || committed[1] != "carol" {
+		t.Errorf("expected alice and carol to commit, got %v", committed)
+	}
+
+	var names []string
+	db.Model(&TestModel{}).Order("name").Pluck("name", &names)
+	if len(names) != 2 || names[0] != "alice" || names[1] != "carol" {
+		t.Errorf("expected alice and carol to be stored, got %v", names)
+	}
+
+	t.Run("nil function", func(t *testing.T) {
+		errs := RunEach[int](ctx, []int{1, 2}, nil)
+		if len(errs) != 2 || errs[0] != ErrNilFunc || errs[1] != ErrNilFunc {
+			t.Errorf("expected ErrNilFunc for every item, got %v", errs)
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package stx
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// Event is a typed event emitted with EmitOnSuccess
+type Event[T any] struct {
+	// Payload is the value passed to EmitOnSuccess
+	Payload T
+	// RequestID is the request ID of the emitting context, see WithRequestID
+	RequestID string
+}
+
+// EventSink receives events of type T once the emitting transaction commits
+type EventSink[T any] func(event Event[T])
+
+var (
+	eventSinksMu sync.RWMutex
+	eventSinks   = make(map[reflect.Type][]any)
+)
+
+// RegisterEventSink registers a sink for events with payload type T. Events
+// are delivered to every sink registered for their type, in registration
+// order. Sinks should be registered during initialization.
+func RegisterEventSink[T any](sink EventSink[T]) {
+	if sink == nil {
+		return
+	}
+
+	key := reflect.TypeOf((*T)(nil)).Elem()
+	eventSinksMu.Lock()
+	eventSinks[key] = append(eventSinks[key], sink)
+	eventSinksMu.Unlock()
+}
+
+// EmitOnSuccess buffers an event that is delivered to the sinks registered for
+// T only after the transaction in ctx commits, and dropped if it rolls back.
+// It is a typed alternative to emitting events from an OnSuccess callback:
+//
+//	stx.RegisterEventSink(func(e stx.Event[UserCreated]) { bus.Publish(e.Payload) })
+//	...
+//	stx.EmitOnSuccess(txCtx, UserCreated{ID: user.ID})
+//
+// As with OnSuccess, the event is delivered immediately outside a
+// transaction. Events without a registered sink are discarded.
+func EmitOnSuccess[T any](ctx context.Context, payload T) {
+	if ctx == nil {
+		return
+	}
+
+	event := Event[T]{Payload: payload, RequestID: RequestID(ctx)}
+	OnSuccess(ctx, func() {
+		eventSinksMu.RLock()
+		sinks := eventSinks[reflect.TypeOf((*T)(nil)).Elem()]
+		eventSinksMu.RUnlock()
+
+		for _, sink := range sinks {
+			sink.(EventSink[T])(event)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testUserCreated struct {
+	Name string
+}
+
+func TestEmitOnSuccess(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := WithRequestID(New(context.Background(), db), "req-1")
+
+	var received []Event[testUserCreated]
+	RegisterEventSink(func(e Event[testUserCreated]) {
+		received = append(received, e)
+	})
+	defer func() {
+		eventSinksMu.Lock()
+		delete(eventSinks, reflect.TypeOf(testUserCreated{}))
+		eventSinksMu.Unlock()
+	}()
+
+	t.Run("delivered after commit", func(t *testing.T) {
+		received = nil
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			EmitOnSuccess(txCtx, testUserCreated{Name: "alice"})
+			EmitOnSuccess(txCtx, testUserCreated{Name: "bob"})
+			// Events of other types don't reach the sink
+			EmitOnSuccess(txCtx, "unrelated")
+
+			if len(received) != 0 {
+				t.Error("expected events to be buffered until commit")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		if len(received) != 2 || received[0].Payload.Name != "alice" || received[1].Payload.Name != "bob" {
+			t.Fatalf("unexpected events %+v", received)
+		}
+		if received[0].RequestID != "req-1" {
+			t.Errorf("expected request ID to be attached, got %q", received[0].RequestID)
+		}
+	})
+
+	t.Run("dropped on rollback", func(t *testing.T) {
+		received = nil
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			EmitOnSuccess(txCtx, testUserCreated{Name: "carol"})
+			return errors.New("rollback")
+		})
+		if err == nil {
+			t.Fatal("expected transaction to fail")
+		}
+		if len(received) != 0 {
+			t.Errorf("expected no events after rollback, got %+v", received)
+		}
+	})
+}
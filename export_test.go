@@ -0,0 +1,5 @@
+package stx
+
+// SetupTestDB exposes setupTestDB to the external stx_test package, whose
+// tests use stxtest and would otherwise form an import cycle
+var SetupTestDB = setupTestDB
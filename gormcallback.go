@@ -0,0 +1,172 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+// scopedCallback is a GORM callback registered with WithGormCallback
+type scopedCallback struct {
+	owner *STX
+	op    string
+	fn    func(*gorm.DB)
+}
+
+// scopedOps lists the operations WithGormCallback supports
+var scopedOps = []string{"create", "query", "update", "delete", "row", "raw"}
+
+var (
+	scopedCallbacksMu sync.RWMutex
+	// scopedCallbacks holds the callbacks registered with WithGormCallback,
+	// keyed by the connection pool of the database transaction they belong to
+	scopedCallbacks = make(map[gorm.ConnPool][]scopedCallback)
+	// scopedCallbackCount is the number of entries in scopedCallbacks, so
+	// the dispatchers can skip the lookup while none are registered. It is
+	// accessed atomically.
+	scopedCallbackCount int64
+	// scopedDispatchers records the GORM callback chains the dispatchers
+	// have been registered on, keyed by *gorm.callbacks, which a DB shares
+	// with its sessions and transactions. scopedDispatchersMu serializes
+	// registrations.
+	scopedDispatchers   sync.Map
+	scopedDispatchersMu sync.Mutex
+)
+
+// WithGormCallback registers fn as a GORM callback that only runs for
+// statements of the transaction in ctx, instead of for every statement on
+// the DB like callbacks registered with db.Callback(). op selects the
+// operation: "create", "query", "update", "delete", "row" or "raw"; fn runs
+// right before the operation's SQL is built and executed, after the model's
+// own Before hooks. The callback is removed automatically when the
+// transaction, or the savepoint it was registered in, ends.
+//
+// The callbacks are run by dispatchers registered with EnableGormCallbacks,
+// and WithGormCallback returns an error on DBs not set up with it. Outside a
+// transaction it returns gorm.ErrInvalidTransaction.
+func WithGormCallback(ctx context.Context, op string, fn func(*gorm.DB)) error {
+	stx := fromContext(ctx)
+	// Bridged transactions are not observed by stx, which could not remove
+	// the callback when they end
+	if stx == nil || !IsTx(ctx) || stx.db == nil {
+		return gorm.ErrInvalidTransaction
+	}
+	if !isScopedOp(op) {
+		return fmt.Errorf("stx: unknown GORM operation %q", op)
+	}
+	if fn == nil {
+		return nil
+	}
+	if _, ok := scopedDispatchers.Load(stx.db.Callback()); !ok {
+		return errors.New("stx: scoped GORM callbacks need a DB set up with EnableGormCallbacks")
+	}
+
+	pool := stx.db.Statement.ConnPool
+	scopedCallbacksMu.Lock()
+	if _, ok := scopedCallbacks[pool]; !ok {
+		atomic.AddInt64(&scopedCallbackCount, 1)
+	}
+	scopedCallbacks[pool] = append(scopedCallbacks[pool], scopedCallback{owner: stx, op: op, fn: fn})
+	scopedCallbacksMu.Unlock()
+	return nil
+}
+
+// isScopedOp reports whether op is supported by WithGormCallback
+func isScopedOp(op string) bool {
+	for _, known := range scopedOps {
+		if op == known {
+			return true
+		}
+	}
+	return false
+}
+
+// EnableGormCallbacks registers the GORM callbacks named "stx:scoped_<op>",
+// which run the callbacks registered with WithGormCallback, on db and the
+// sessions and transactions derived from it. Like EnableQueryErrors, call it
+// once during setup, before db serves queries: GORM does not synchronize
+// registering callbacks with statements running concurrently. Calling it
+// again for the same DB does nothing.
+func EnableGormCallbacks(db *gorm.DB) error {
+	if db == nil {
+		return gorm.ErrInvalidDB
+	}
+
+	scopedDispatchersMu.Lock()
+	defer scopedDispatchersMu.Unlock()
+
+	callbacks := db.Callback()
+	if _, ok := scopedDispatchers.Load(callbacks); ok {
+		return nil
+	}
+
+	registers := map[string]func(name string, fn func(*gorm.DB)) error{
+		"create": callbacks.Create().Before("gorm:create").Register,
+		"query":  callbacks.Query().Before("gorm:query").Register,
+		"update": callbacks.Update().Before("gorm:update").Register,
+		"delete": callbacks.Delete().Before("gorm:delete").Register,
+		"row":    callbacks.Row().Before("gorm:row").Register,
+		"raw":    callbacks.Raw().Before("gorm:raw").Register,
+	}
+	for _, op := range scopedOps {
+		op := op
+		if err := registers[op]("stx:scoped_"+op, func(db *gorm.DB) {
+			runScopedCallbacks(db, op)
+		}); err != nil {
+			return err
+		}
+	}
+	scopedDispatchers.Store(callbacks, true)
+	return nil
+}
+
+// runScopedCallbacks runs the scoped callbacks for op of the transaction db
+// belongs to
+func runScopedCallbacks(db *gorm.DB, op string) {
+	if atomic.LoadInt64(&scopedCallbackCount) == 0 {
+		return
+	}
+
+	scopedCallbacksMu.RLock()
+	callbacks := scopedCallbacks[db.Statement.ConnPool]
+	scopedCallbacksMu.RUnlock()
+
+	for _, callback := range callbacks {
+		if callback.op == op {
+			callback.fn(db)
+		}
+	}
+}
+
+// removeScopedCallbacks removes the callbacks registered in stx
+func (stx *STX) removeScopedCallbacks() {
+	if stx.db == nil {
+		return
+	}
+
+	pool := stx.db.Statement.ConnPool
+	scopedCallbacksMu.Lock()
+	defer scopedCallbacksMu.Unlock()
+
+	callbacks, ok := scopedCallbacks[pool]
+	if !ok {
+		return
+	}
+
+	kept := callbacks[:0]
+	for _, callback := range callbacks {
+		if callback.owner != stx {
+			kept = append(kept, callback)
+		}
+	}
+	if len(kept) == 0 {
+		delete(scopedCallbacks, pool)
+		atomic.AddInt64(&scopedCallbackCount, -1)
+		return
+	}
+	scopedCallbacks[pool] = kept
+}
@@ -0,0 +1,125 @@
+package stx
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestWithGormCallback(t *testing.T) {
+	db := setupTestDB(t)
+	if err := EnableGormCallbacks(db); err != nil {
+		t.Fatalf("failed to enable GORM callbacks: %v", err)
+	}
+	ctx := New(context.Background(), db)
+
+	var created []string
+	record := func(db *gorm.DB) {
+		if model, ok := db.Statement.Dest.(*TestModel); ok {
+			created = append(created, model.Name)
+		}
+	}
+
+	err := WithTransaction(ctx, func(txCtx context.Context) error {
+		if err := WithGormCallback(txCtx, "create", record); err != nil {
+			return err
+		}
+		// Statements outside the transaction are not affected. SQLite locks
+		// the table once the transaction writes, so this comes first.
+		if err := db.Create(&TestModel{Name: "outside"}).Error; err != nil {
+			return err
+		}
+		return Current(txCtx).Create(&TestModel{Name: "scoped"}).Error
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+
+	err = WithTransaction(ctx, func(txCtx context.Context) error {
+		return Current(txCtx).Create(&TestModel{Name: "other-tx"}).Error
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+	db.Create(&TestModel{Name: "after"})
+
+	if len(created) != 1 || created[0] != "scoped" {
+		t.Errorf("expected callback to run only within its transaction, got %v", created)
+	}
+
+	t.Run("removed when savepoint ends", func(t *testing.T) {
+		created = nil
+		txCtx := Begin(ctx)
+		defer Rollback(txCtx)
+
+		spCtx := Begin(txCtx)
+		if err := WithGormCallback(spCtx, "create", record); err != nil {
+			t.Fatalf("failed to register callback: %v", err)
+		}
+		Current(spCtx).Create(&TestModel{Name: "savepoint"})
+		if err := Commit(spCtx); err != nil {
+			t.Fatalf("commit failed: %v", err)
+		}
+		Current(txCtx).Create(&TestModel{Name: "enclosing"})
+
+		if len(created) != 1 || created[0] != "savepoint" {
+			t.Errorf("expected callback to run only within the savepoint, got %v", created)
+		}
+	})
+
+	t.Run("outside a transaction", func(t *testing.T) {
+		if err := WithGormCallback(ctx, "create", record); err != gorm.ErrInvalidTransaction {
+			t.Errorf("expected ErrInvalidTransaction, got %v", err)
+		}
+	})
+
+	t.Run("unknown operation", func(t *testing.T) {
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			return WithGormCallback(txCtx, "bogus", record)
+		})
+		if err == nil {
+			t.Error("expected error for unknown operation")
+		}
+	})
+
+	t.Run("opt-in", func(t *testing.T) {
+		db := setupTestDB(t)
+		ctx := New(context.Background(), db)
+		if db.Callback().Query().Get("stx:scoped_query") != nil {
+			t.Fatal("expected New not to register dispatchers")
+		}
+
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			return WithGormCallback(txCtx, "create", record)
+		})
+		if err == nil {
+			t.Error("expected an error on a DB not set up with EnableGormCallbacks")
+		}
+
+		if err := EnableGormCallbacks(db); err != nil {
+			t.Fatalf("failed to enable GORM callbacks: %v", err)
+		}
+		if err := EnableGormCallbacks(db); err != nil {
+			t.Fatalf("expected enabling twice to do nothing, got %v", err)
+		}
+		callbacks := db.Callback()
+		registered := map[string]func(*gorm.DB){
+			"create": callbacks.Create().Get("stx:scoped_create"),
+			"query":  callbacks.Query().Get("stx:scoped_query"),
+			"update": callbacks.Update().Get("stx:scoped_update"),
+			"delete": callbacks.Delete().Get("stx:scoped_delete"),
+			"row":    callbacks.Row().Get("stx:scoped_row"),
+			"raw":    callbacks.Raw().Get("stx:scoped_raw"),
+		}
+		for op, fn := range registered {
+			if fn == nil {
+				t.Errorf("expected dispatcher for %s to be registered", op)
+			}
+		}
+
+		if err := EnableGormCallbacks(nil); err != gorm.ErrInvalidDB {
+			t.Errorf("expected ErrInvalidDB for a nil DB, got %v", err)
+		}
+	})
+}
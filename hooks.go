@@ -0,0 +1,117 @@
+package stx
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	beginHooksMu sync.RWMutex
+	beginHooks   []func(ctx context.Context)
+)
+
+// OnBegin registers a global hook that is called whenever Begin,
+// WithTransaction or WithDefer starts a transaction, including nested ones
+// running on a savepoint. The hook receives the new transaction's context,
+// which makes it a good place to start tracing spans or tag connections.
+// Hooks run synchronously in registration order and should be registered
+// during initialization.
+func OnBegin(hook func(ctx context.Context)) {
+	if hook == nil {
+		return
+	}
+
+	beginHooksMu.Lock()
+	beginHooks = append(beginHooks, hook)
+	beginHooksMu.Unlock()
+}
+
+// runBeginHooks calls the hooks registered with OnBegin
+func runBeginHooks(ctx context.Context) {
+	beginHooksMu.RLock()
+	hooks := beginHooks
+	beginHooksMu.RUnlock()
+
+	for _, hook := range hooks {
+		hook(ctx)
+	}
+}
+
+// HookSet describes the global hooks currently registered, see Hooks
+type HookSet struct {
+	// BeginHooks is the number of hooks registered with OnBegin
+	BeginHooks int
+	// EventSinks holds the payload types with sinks registered with
+	// RegisterEventSink, sorted by name
+	EventSinks []string
+	// BatchHandlers holds the keys with handlers registered with
+	// RegisterBatchHandler, sorted
+	BatchHandlers []string
+	// CustomIDGenerator reports whether SetIDGenerator replaced the default
+	// transaction ID generator
+	CustomIDGenerator bool
+	// CallbackOverrunHandler reports whether SetCallbackOverrunHandler
+	// replaced the default overrun warning
+	CallbackOverrunHandler bool
+
+	// The remaining fields hold the global settings, as last passed to
+	// Configure or, where one exists, the Set function of the same name
+	DefaultIsolation     sql.IsolationLevel
+	DefaultTimeout       time.Duration
+	MaxRetries           int
+	CallbackTimeout      time.Duration
+	MaxLifetime          time.Duration
+	NestingWarnThreshold int
+	StrictDefer          bool
+	DetectStaleContext   bool
+}
+
+// Hooks returns the global hooks currently registered and the global
+// settings they work with. It is meant for debugging the wiring at startup,
+// for example to verify that every hook was registered before the first
+// transaction.
+func Hooks() HookSet {
+	var set HookSet
+
+	beginHooksMu.RLock()
+	set.BeginHooks = len(beginHooks)
+	beginHooksMu.RUnlock()
+
+	eventSinksMu.RLock()
+	for typ, sinks := range eventSinks {
+		if len(sinks) > 0 {
+			set.EventSinks = append(set.EventSinks, typ.String())
+		}
+	}
+	eventSinksMu.RUnlock()
+	sort.Strings(set.EventSinks)
+
+	batchHandlersMu.RLock()
+	for key := range batchHandlers {
+		set.BatchHandlers = append(set.BatchHandlers, key)
+	}
+	batchHandlersMu.RUnlock()
+	sort.Strings(set.BatchHandlers)
+
+	idGeneratorMu.RLock()
+	set.CustomIDGenerator = idGenerator != nil
+	idGeneratorMu.RUnlock()
+
+	callbackOverrunMu.RLock()
+	set.CallbackOverrunHandler = callbackOverrunHandler != nil
+	callbackOverrunMu.RUnlock()
+
+	set.DefaultIsolation = sql.IsolationLevel(atomic.LoadInt64(&defaultIsolation))
+	set.DefaultTimeout = time.Duration(atomic.LoadInt64(&defaultTimeout))
+	set.MaxRetries = int(atomic.LoadInt64(&maxRetries))
+	set.CallbackTimeout = time.Duration(atomic.LoadInt64(&callbackTimeout))
+	set.MaxLifetime = time.Duration(atomic.LoadInt64(&maxLifetime))
+	set.NestingWarnThreshold = int(atomic.LoadInt64(&nestingWarnThreshold))
+	set.StrictDefer = atomic.LoadInt32(&strictDefer) == 1
+	set.DetectStaleContext = atomic.LoadUint32(&detectStaleContext) == 1
+	return set
+}
@@ -0,0 +1,139 @@
+package stx
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOnBegin(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	beginHooksMu.Lock()
+	saved := beginHooks
+	beginHooksMu.Unlock()
+	defer func() {
+		beginHooksMu.Lock()
+		beginHooks = saved
+		beginHooksMu.Unlock()
+	}()
+
+	var starts int
+	var nonTx int
+	OnBegin(func(ctx context.Context) {
+		starts++
+		if !IsTx(ctx) {
+			nonTx++
+		}
+	})
+
+	err := WithTransaction(ctx, func(txCtx context.Context) error {
+		return WithTransaction(txCtx, func(context.Context) error { return nil })
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+	if starts != 2 {
+		t.Errorf("expected hook to fire for outer and nested WithTransaction, got %d", starts)
+	}
+
+	err = func() (err error) {
+		txCtx, cleanup := WithDefer(ctx)
+		defer cleanup(&err)
+		_, innerCleanup := WithDefer(txCtx)
+		defer innerCleanup(&err)
+		return nil
+	}()
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+	if starts != 4 {
+		t.Errorf("expected hook to fire for outer and nested WithDefer, got %d total", starts)
+	}
+
+	txCtx := Begin(ctx)
+	if err := Commit(txCtx); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	if starts != 5 {
+		t.Errorf("expected hook to fire for Begin, got %d total", starts)
+	}
+
+	if nonTx != 0 {
+		t.Errorf("expected hook to receive transactional contexts, got %d without", nonTx)
+	}
+}
+
+type hookSetEvent struct{}
+
+func TestHooks(t *testing.T) {
+	beginHooksMu.Lock()
+	saved := beginHooks
+	beginHooks = nil
+	beginHooksMu.Unlock()
+	defer func() {
+		beginHooksMu.Lock()
+		beginHooks = saved
+		beginHooksMu.Unlock()
+	}()
+	defer func() {
+		eventSinksMu.Lock()
+		delete(eventSinks, reflect.TypeOf(hookSetEvent{}))
+		eventSinksMu.Unlock()
+	}()
+	defer RegisterBatchHandler("hookset", nil)
+	defer SetIDGenerator(nil)
+	defer SetCallbackOverrunHandler(nil)
+	defer SetCallbackTimeout(0)
+	defer SetMaxLifetime(0)
+	defer Configure(Config{})
+
+	before := Hooks()
+	if before.BeginHooks != 0 || before.CustomIDGenerator || before.CallbackOverrunHandler {
+		t.Fatalf("expected no begin hooks, ID generator or overrun handler, got %+v", before)
+	}
+
+	OnBegin(func(context.Context) {})
+	RegisterEventSink(func(Event[hookSetEvent]) {})
+	RegisterBatchHandler("hookset", func([]any) {})
+	SetIDGenerator(func() string { return "id" })
+	SetCallbackOverrunHandler(func(time.Duration) {})
+	Configure(Config{DefaultIsolation: sql.LevelSerializable, DefaultTimeout: time.Hour, MaxRetries: 3})
+	SetCallbackTimeout(time.Second)
+	SetMaxLifetime(time.Minute)
+
+	set := Hooks()
+	if set.BeginHooks != 1 {
+		t.Errorf("expected one begin hook, got %d", set.BeginHooks)
+	}
+	if !contains(set.EventSinks, "stx.hookSetEvent") {
+		t.Errorf("expected the event sink to be listed, got %v", set.EventSinks)
+	}
+	if !contains(set.BatchHandlers, "hookset") {
+		t.Errorf("expected the batch handler to be listed, got %v", set.BatchHandlers)
+	}
+	if !set.CustomIDGenerator {
+		t.Error("expected the custom ID generator to be reported")
+	}
+	if !set.CallbackOverrunHandler {
+		t.Error("expected the overrun handler to be reported")
+	}
+	if set.CallbackTimeout != time.Second || set.MaxLifetime != time.Minute {
+		t.Errorf("expected the configured timeouts, got %v and %v", set.CallbackTimeout, set.MaxLifetime)
+	}
+	if set.DefaultIsolation != sql.LevelSerializable || set.DefaultTimeout != time.Hour || set.MaxRetries != 3 {
+		t.Errorf("expected the configured defaults, got %+v", set)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
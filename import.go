@@ -0,0 +1,53 @@
+package stx
+
+import (
+	"context"
+	"fmt"
+)
+
+const atomicImportContextKey contextKey = "stx:atomic_import"
+
+// WithAtomicImport returns a context in which ChunkedImport inserts all
+// records in a single transaction instead of committing chunk by chunk.
+func WithAtomicImport(ctx context.Context) context.Context {
+	return context.WithValue(ctx, atomicImportContextKey, true)
+}
+
+// ChunkedImport inserts records in chunks of chunkSize, each in its own
+// transaction, so bulk imports don't hold one huge transaction open.
+//
+// The import is NOT atomic by default: when a chunk fails, the chunks before
+// it stay committed and the returned error reports how many records were
+// imported. Use WithAtomicImport to insert everything in one transaction that
+// rolls back as a whole. When ctx is already in a transaction, chunks are
+// committed as savepoints and only persist if that transaction commits.
+func ChunkedImport[T any](ctx context.Context, records []T, chunkSize int) error {
+	if chunkSize <= 0 {
+		chunkSize = len(records)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	if whole, _ := ctx.Value(atomicImportContextKey).(bool); whole {
+		return withTransaction(ctx, func(txCtx context.Context) error {
+			return Current(txCtx).CreateInBatches(records, chunkSize).Error
+		}, false)
+	}
+
+	for start := 0; start < len(records); start += chunkSize {
+		end := start + chunkSize
+		if end > len(records) {
+			end = len(records)
+		}
+
+		chunk := records[start:end]
+		err := withTransaction(ctx, func(txCtx context.Context) error {
+			return Current(txCtx).Create(chunk).Error
+		}, false)
+		if err != nil {
+			return newSTXError(fmt.Sprintf("import failed after %d of %d records", start, len(records)), err)
+		}
+	}
+	return nil
+}
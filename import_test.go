@@ -0,0 +1,65 @@
+package stx
+
+import (
+	"context"
+	"testing"
+)
+
+func TestChunkedImport(t *testing.T) {
+	// The third record duplicates the first one's primary key, failing the
+	// second chunk
+	newRecords := func() []TestModel {
+		return []TestModel{
+			{ID: 1, Name: "a"},
+			{ID: 2, Name: "b"},
+			{ID: 1, Name: "c"},
+			{ID: 4, Name: "d"},
+		}
+	}
+
+	t.Run("chunked keeps earlier chunks on failure", func(t *testing.T) {
+		db := setupTestDB(t)
+		ctx := New(context.Background(), db)
+
+		if err := ChunkedImport(ctx, newRecords(), 2); err == nil {
+			t.Fatal("expected import to fail")
+		}
+
+		var count int64
+		db.Model(&TestModel{}).Count(&count)
+		if count != 2 {
+			t.Errorf("expected first chunk to persist, got %d records", count)
+		}
+	})
+
+	t.Run("atomic rolls back everything on failure", func(t *testing.T) {
+		db := setupTestDB(t)
+		ctx := WithAtomicImport(New(context.Background(), db))
+
+		if err := ChunkedImport(ctx, newRecords(), 2); err == nil {
+			t.Fatal("expected import to fail")
+		}
+
+		var count int64
+		db.Model(&TestModel{}).Count(&count)
+		if count != 0 {
+			t.Errorf("expected no records after atomic failure, got %d", count)
+		}
+	})
+
+	t.Run("imports all chunks", func(t *testing.T) {
+		db := setupTestDB(t)
+		ctx := New(context.Background(), db)
+
+		records := []TestModel{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}, {Name: "e"}}
+		if err := ChunkedImport(ctx, records, 2); err != nil {
+			t.Fatalf("import failed: %v", err)
+		}
+
+		var count int64
+		db.Model(&TestModel{}).Count(&count)
+		if count != 5 {
+			t.Errorf("expected 5 records, got %d", count)
+		}
+	})
+}
@@ -0,0 +1,16 @@
+package stx
+
+import "sync/atomic"
+
+// openTransactions counts the transactions begun with Begin that have not
+// ended yet
+var openTransactions int64
+
+// OpenTransactions returns the number of transactions started with Begin,
+// BeginCancelable or WithDefer that have neither been committed nor rolled
+// back. Savepoints and WithTransaction calls, which always end, are not
+// counted. It is meant for tests that check for leaked transactions; see the
+// stxtest package.
+func OpenTransactions() int {
+	return int(atomic.LoadInt64(&openTransactions))
+}
@@ -0,0 +1,85 @@
+package stx
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrTransactionTimeout is returned when a transaction was aborted because it
+// exceeded the maximum lifetime set with SetMaxLifetime.
+var ErrTransactionTimeout = errors.New("transaction exceeded maximum lifetime")
+
+// maxLifetime holds the configured maximum lifetime in nanoseconds
+var maxLifetime int64
+
+// SetMaxLifetime sets the maximum lifetime of transactions started with Begin
+// or WithDefer. A transaction still open after d is rolled back in the
+// background; Commit, Rollback and the WithDefer cleanup then report
+// ErrTransactionTimeout. A zero or negative d disables the limit, which is the
+// default. The setting applies to transactions started after the call.
+func SetMaxLifetime(d time.Duration) {
+	atomic.StoreInt64(&maxLifetime, int64(d))
+}
+
+// startLifetimeTimer arms the abort timer if a maximum lifetime is configured
+func (stx *STX) startLifetimeTimer() {
+	d := time.Duration(atomic.LoadInt64(&maxLifetime))
+	if d <= 0 {
+		return
+	}
+
+	stx.mu.Lock()
+	stx.timer = time.AfterFunc(d, func() { stx.abort(ErrTransactionTimeout) })
+	stx.mu.Unlock()
+}
+
+// abort rolls the transaction back unless it has already finished. Commit,
+// Rollback and the WithDefer cleanup then report cause. It is safe to call
+// while the owner of the transaction is running queries on it.
+func (stx *STX) abort(cause error) {
+	stx.mu.Lock()
+	if stx.finished || stx.aborted != nil {
+		stx.mu.Unlock()
+		return
+	}
+	stx.aborted = cause
+	stx.mu.Unlock()
+	stx.release()
+
+	// Roll back on the underlying connection rather than through stx.db so the
+	// shared *gorm.DB isn't mutated while its owner may still be using it
+	if committer, ok := stx.db.Statement.ConnPool.(gorm.TxCommitter); ok && committer != nil {
+		committer.Rollback()
+	}
+	stx.discardConn()
+	stx.end(TxRolledBack)
+}
+
+// finish marks the transaction as finished and stops its lifetime timer. It
+// returns the cause if the transaction was already aborted, for example
+// ErrTransactionTimeout if the lifetime timer fired.
+func (stx *STX) finish() error {
+	stx.mu.Lock()
+	defer stx.mu.Unlock()
+
+	if stx.aborted != nil {
+		return stx.aborted
+	}
+	stx.release()
+	stx.finished = true
+	if stx.timer != nil {
+		stx.timer.Stop()
+	}
+	return nil
+}
+
+// isFinished reports whether the transaction has been committed, rolled back
+// or aborted
+func (stx *STX) isFinished() bool {
+	stx.mu.RLock()
+	defer stx.mu.RUnlock()
+	return stx.finished || stx.aborted != nil
+}
@@ -0,0 +1,95 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMaxLifetime(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	t.Run("slow transaction is aborted", func(t *testing.T) {
+		SetMaxLifetime(50 * time.Millisecond)
+		defer SetMaxLifetime(0)
+
+		var callbackExecuted bool
+		err := func() (err error) {
+			txCtx, cleanup := WithDefer(ctx)
+			defer cleanup(&err)
+
+			OnSuccess(txCtx, func() {
+				callbackExecuted = true
+			})
+			model := TestModel{Name: "lifetime-slow"}
+			if err := Current(txCtx).Create(&model).Error; err != nil {
+				return err
+			}
+
+			time.Sleep(150 * time.Millisecond)
+			return nil
+		}()
+		if !errors.Is(err, ErrTransactionTimeout) {
+			t.Fatalf("expected ErrTransactionTimeout, got: %v", err)
+		}
+		if callbackExecuted {
+			t.Error("callback should not be executed after timeout")
+		}
+
+		var count int64
+		db.Model(&TestModel{}).Where("name = ?", "lifetime-slow").Count(&count)
+		if count != 0 {
+			t.Errorf("expected 0 records after timeout, got %d", count)
+		}
+	})
+
+	t.Run("error after abort reports timeout", func(t *testing.T) {
+		SetMaxLifetime(50 * time.Millisecond)
+		defer SetMaxLifetime(0)
+
+		err := func() (err error) {
+			txCtx, cleanup := WithDefer(ctx)
+			defer cleanup(&err)
+
+			time.Sleep(150 * time.Millisecond)
+			model := TestModel{Name: "lifetime-after-abort"}
+			return Current(txCtx).Create(&model).Error
+		}()
+		if !errors.Is(err, ErrTransactionTimeout) {
+			t.Fatalf("expected ErrTransactionTimeout, got: %v", err)
+		}
+	})
+
+	t.Run("fast transaction stops timer", func(t *testing.T) {
+		SetMaxLifetime(time.Second)
+		defer SetMaxLifetime(0)
+
+		txCtx := Begin(ctx)
+		stx := fromContext(txCtx)
+		if stx.timer == nil {
+			t.Fatal("expected lifetime timer to be armed")
+		}
+
+		model := TestModel{Name: "lifetime-fast"}
+		if err := Current(txCtx).Create(&model).Error; err != nil {
+			t.Fatalf("failed to create model: %v", err)
+		}
+		if err := Commit(txCtx); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+		if stx.timer.Stop() {
+			t.Error("expected timer to be stopped after commit")
+		}
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		txCtx := Begin(ctx)
+		defer Rollback(txCtx)
+
+		if fromContext(txCtx).timer != nil {
+			t.Error("expected no lifetime timer by default")
+		}
+	})
+}
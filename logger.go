@@ -0,0 +1,60 @@
+package stx
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+// loggerWrapper is implemented by the GORM loggers stx installs on a
+// transaction in front of its original logger
+type loggerWrapper interface {
+	unwrap() logger.Interface
+}
+
+// wrappedLogger passes every call on to the next logger. The loggers stx
+// installs embed it and override the methods they record from, as well as
+// LogMode so the level change keeps them in front of the next logger.
+type wrappedLogger struct {
+	next logger.Interface
+}
+
+func (l wrappedLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return l.next.LogMode(level)
+}
+
+func (l wrappedLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	l.next.Info(ctx, msg, data...)
+}
+
+func (l wrappedLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	l.next.Warn(ctx, msg, data...)
+}
+
+func (l wrappedLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	l.next.Error(ctx, msg, data...)
+}
+
+func (l wrappedLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	l.next.Trace(ctx, begin, fc, err)
+}
+
+func (l wrappedLogger) unwrap() logger.Interface {
+	return l.next
+}
+
+// hasLogger reports whether l, or a logger wrapped by it, matches
+func hasLogger(l logger.Interface, match func(logger.Interface) bool) bool {
+	for l != nil {
+		if match(l) {
+			return true
+		}
+		w, ok := l.(loggerWrapper)
+		if !ok {
+			return false
+		}
+		l = w.unwrap()
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package stx
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// nestingWarnInterval is the minimum time between two nesting warnings
+const nestingWarnInterval = time.Minute
+
+var (
+	// nestingWarnThreshold is the nesting depth above which a warning is
+	// logged, or 0 if disabled
+	nestingWarnThreshold int64
+	// lastNestingWarn is the time of the last nesting warning in Unix
+	// nanoseconds
+	lastNestingWarn int64
+)
+
+// SetNestingWarnThreshold makes stx log a warning through the DB's GORM
+// logger when a transaction is nested more than n levels deep, counting the
+// outermost transaction as level 1. Deep nesting often points at helpers that
+// each open their own transaction. Warnings are rate-limited to one per
+// minute to avoid flooding the log. A zero or negative n disables the warning,
+// which is the default.
+func SetNestingWarnThreshold(n int) {
+	atomic.StoreInt64(&nestingWarnThreshold, int64(n))
+}
+
+// warnIfDeeplyNested logs a rate-limited warning if the transaction's depth
+// crosses the configured threshold
+func (stx *STX) warnIfDeeplyNested(ctx context.Context) {
+	threshold := atomic.LoadInt64(&nestingWarnThreshold)
+	if threshold <= 0 || int64(stx.depth) <= threshold {
+		return
+	}
+	if stx.db == nil || stx.db.Logger == nil {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&lastNestingWarn)
+	if now-last < int64(nestingWarnInterval) || !atomic.CompareAndSwapInt64(&lastNestingWarn, last, now) {
+		return
+	}
+
+	stx.db.Logger.Warn(ctx, "stx: transaction nested %d levels deep, exceeding the warning threshold of %d", stx.depth, threshold)
+}
@@ -0,0 +1,77 @@
+package stx
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestNestingWarnThreshold(t *testing.T) {
+	log := &capturingLogger{}
+	db := setupTestDB(t).Session(&gorm.Session{Logger: log})
+	ctx := New(context.Background(), db)
+
+	SetNestingWarnThreshold(2)
+	defer SetNestingWarnThreshold(0)
+	atomic.StoreInt64(&lastNestingWarn, 0)
+
+	var nest func(ctx context.Context, levels int) error
+	nest = func(ctx context.Context, levels int) error {
+		if levels == 0 {
+			return nil
+		}
+		return WithTransaction(ctx, func(txCtx context.Context) error {
+			return nest(txCtx, levels-1)
+		})
+	}
+
+	t.Run("below threshold", func(t *testing.T) {
+		if err := nest(ctx, 2); err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if n := log.warningCount(); n != 0 {
+			t.Errorf("expected no warnings at the threshold, got %d", n)
+		}
+	})
+
+	t.Run("beyond threshold warns once per window", func(t *testing.T) {
+		// Crosses the threshold at depth 3 and 4, then again in a second run
+		if err := nest(ctx, 4); err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if err := nest(ctx, 3); err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		if n := log.warningCount(); n != 1 {
+			t.Fatalf("expected exactly one warning within the rate window, got %d", n)
+		}
+		if !strings.Contains(log.warnings[0], "nested 3 levels deep") {
+			t.Errorf("expected warning to include the depth, got %q", log.warnings[0])
+		}
+	})
+
+	t.Run("WithDefer savepoints count", func(t *testing.T) {
+		atomic.StoreInt64(&lastNestingWarn, 0)
+		before := log.warningCount()
+
+		err := func() (err error) {
+			c1, cleanup1 := WithDefer(ctx)
+			defer cleanup1(&err)
+			c2, cleanup2 := WithDefer(c1)
+			defer cleanup2(&err)
+			_, cleanup3 := WithDefer(c2)
+			defer cleanup3(&err)
+			return nil
+		}()
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if n := log.warningCount() - before; n != 1 {
+			t.Errorf("expected one warning for nested WithDefer, got %d", n)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package stx_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/restayway/stx"
+	"github.com/restayway/stx/stxtest"
+)
+
+func TestOnSuccessCallbacks(t *testing.T) {
+	ctx := stx.New(context.Background(), stx.SetupTestDB(t))
+
+	// withDefer runs fn in a transaction started with WithDefer
+	withDefer := func(ctx context.Context, fn func(txCtx context.Context) error) (err error) {
+		txCtx, cleanup := stx.WithDefer(ctx)
+		defer cleanup(&err)
+		return fn(txCtx)
+	}
+
+	t.Run("successful transaction", func(t *testing.T) {
+		recCtx, rec := stxtest.RecordingContext(ctx)
+		err := withDefer(recCtx, func(txCtx context.Context) error {
+			stx.OnSuccess(txCtx, rec.Callback("success"))
+			return stx.Current(txCtx).Create(&stx.TestModel{Name: "success-test"}).Error
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		if fired := rec.Fired(); !reflect.DeepEqual(fired, []string{"success"}) {
+			t.Errorf("expected callback to fire after commit, got %v", fired)
+		}
+	})
+
+	t.Run("rolled back", func(t *testing.T) {
+		recCtx, rec := stxtest.RecordingContext(ctx)
+		err := withDefer(recCtx, func(txCtx context.Context) error {
+			stx.OnSuccess(txCtx, rec.Callback("rollback"))
+			if err := stx.Current(txCtx).Create(&stx.TestModel{Name: "rollback-test"}).Error; err != nil {
+				return err
+			}
+			return errors.New("forced rollback")
+		})
+		if err == nil {
+			t.Fatal("expected error to trigger rollback")
+		}
+
+		if n := rec.Count(); n != 0 {
+			t.Errorf("expected no callbacks after rollback, got %d", n)
+		}
+	})
+
+	t.Run("rolled back by panic", func(t *testing.T) {
+		recCtx, rec := stxtest.RecordingContext(ctx)
+		err := withDefer(recCtx, func(txCtx context.Context) error {
+			stx.OnSuccess(txCtx, rec.Callback("panic"))
+			if err := stx.Current(txCtx).Create(&stx.TestModel{Name: "panic-test"}).Error; err != nil {
+				return err
+			}
+			panic("test panic")
+		})
+		if err == nil {
+			t.Fatal("expected error from panic recovery")
+		}
+
+		if n := rec.Count(); n != 0 {
+			t.Errorf("expected no callbacks after panic rollback, got %d", n)
+		}
+	})
+
+	t.Run("without transaction", func(t *testing.T) {
+		_, rec := stxtest.RecordingContext(ctx)
+		stx.OnSuccess(context.Background(), rec.Callback("immediate"))
+
+		if fired := rec.Fired(); !reflect.DeepEqual(fired, []string{"immediate"}) {
+			t.Errorf("expected callback to fire immediately, got %v", fired)
+		}
+	})
+
+	t.Run("registration order", func(t *testing.T) {
+		recCtx, rec := stxtest.RecordingContext(ctx)
+		err := withDefer(recCtx, func(txCtx context.Context) error {
+			for i := 1; i <= 5; i++ {
+				stx.OnSuccess(txCtx, rec.Callback(fmt.Sprint(i)))
+			}
+			return stx.Current(txCtx).Create(&stx.TestModel{Name: "order-preservation-test"}).Error
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		if fired := rec.Fired(); !reflect.DeepEqual(fired, []string{"1", "2", "3", "4", "5"}) {
+			t.Errorf("expected callbacks in registration order, got %v", fired)
+		}
+	})
+
+	t.Run("concurrent registration", func(t *testing.T) {
+		recCtx, rec := stxtest.RecordingContext(ctx)
+		err := withDefer(recCtx, func(txCtx context.Context) error {
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					stx.OnSuccess(txCtx, rec.Callback("concurrent"))
+				}()
+			}
+			wg.Wait()
+			return stx.Current(txCtx).Create(&stx.TestModel{Name: "concurrent-callbacks-test"}).Error
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		if n := len(rec.Fired()); n != 10 {
+			t.Errorf("expected 10 callbacks, got %d", n)
+		}
+	})
+
+	t.Run("nested transactions", func(t *testing.T) {
+		recCtx, rec := stxtest.RecordingContext(ctx)
+		err := stx.WithTransaction(recCtx, func(outerCtx context.Context) error {
+			stx.OnSuccess(outerCtx, rec.Callback("outer"))
+			if err := stx.Current(outerCtx).Create(&stx.TestModel{Name: "outer-nested"}).Error; err != nil {
+				return err
+			}
+
+			return stx.WithTransaction(outerCtx, func(innerCtx context.Context) error {
+				stx.OnSuccess(innerCtx, rec.Callback("inner"))
+				return stx.Current(innerCtx).Create(&stx.TestModel{Name: "inner-nested"}).Error
+			})
+		})
+		if err != nil {
+			t.Fatalf("nested transaction failed: %v", err)
+		}
+
+		// The inner callbacks run when the savepoint is released
+		if fired := rec.Fired(); !reflect.DeepEqual(fired, []string{"inner", "outer"}) {
+			t.Errorf("expected inner then outer callback, got %v", fired)
+		}
+	})
+
+	t.Run("one callback per transaction", func(t *testing.T) {
+		recCtx, rec := stxtest.RecordingContext(stx.New(context.Background(), stx.SetupTestDB(t)))
+		const numTransactions = 50
+		for i := 0; i < numTransactions; i++ {
+			err := withDefer(recCtx, func(txCtx context.Context) error {
+				stx.OnSuccess(txCtx, rec.Callback("stress"))
+				return stx.Current(txCtx).Create(&stx.TestModel{Name: fmt.Sprintf("stress-test-%d", i)}).Error
+			})
+			if err != nil {
+				t.Errorf("transaction %d failed: %v", i, err)
+			}
+		}
+
+		if n := rec.Count(); n != numTransactions {
+			t.Errorf("expected %d callbacks, got %d", numTransactions, n)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+const retryAttemptContextKey contextKey = "stx:retry_attempt"
+
+// retryAttempt describes the running attempt of WithOptimisticRetry
+type retryAttempt struct {
+	attempt, max int
+}
+
+// ErrVersionConflict is returned by CheckVersion when an optimistic locking
+// update matched no rows, because another transaction changed the row first.
+var ErrVersionConflict = errors.New("stx: optimistic lock version conflict")
+
+// CheckVersion returns result's error, or ErrVersionConflict if the statement
+// succeeded but affected no rows. Use it on updates guarded by a version
+// column:
+//
+//	result := stx.Current(ctx).Model(&item).
+//	    Where("version = ?", item.Version).
+//	    Updates(map[string]any{"name": name, "version": item.Version + 1})
+//	if err := stx.CheckVersion(result); err != nil {
+//	    return err
+//	}
+func CheckVersion(result *gorm.DB) error {
+	if result == nil {
+		return nil
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// WithOptimisticRetry runs fn in a transaction like WithTransaction and runs
+// it again, in a new transaction, as long as it fails with
+// ErrVersionConflict, up to maxAttempts attempts in total. fn must reload the
+// rows it updates on every attempt, as a conflict means its copy is stale.
+// Other errors are returned immediately. When all attempts conflict, the last
+// ErrVersionConflict is returned. A maxAttempts below 1 makes one attempt
+// plus the retries set with Configure. Within fn, RetryAttempt reports which
+// attempt is running.
+func WithOptimisticRetry(ctx context.Context, maxAttempts int, fn func(context.Context) error) error {
+	if fn == nil {
+		return ErrNilFunc
+	}
+	if maxAttempts < 1 {
+		maxAttempts = 1 + int(atomic.LoadInt64(&maxRetries))
+	}
+
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		attemptCtx := context.WithValue(ctx, retryAttemptContextKey, retryAttempt{attempt: attempt + 1, max: maxAttempts})
+		err = withTransaction(attemptCtx, fn, false)
+		if !errors.Is(err, ErrVersionConflict) {
+			return err
+		}
+	}
+	return err
+}
+
+// RetryAttempt returns the attempt running under WithOptimisticRetry,
+// counting from 1, and the maximum number of attempts, so code can behave
+// differently on the last one, for example by skipping speculative work. The
+// bool is false outside WithOptimisticRetry.
+func RetryAttempt(ctx context.Context) (attempt, max int, ok bool) {
+	r, ok := ctx.Value(retryAttemptContextKey).(retryAttempt)
+	return r.attempt, r.max, ok
+}
@@ -0,0 +1,119 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type versionedModel struct {
+	ID      uint `gorm:"primaryKey"`
+	Name    string
+	Version int
+}
+
+func TestWithOptimisticRetry(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&versionedModel{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	ctx := New(context.Background(), db)
+
+	row := versionedModel{Name: "initial", Version: 1}
+	if err := db.Create(&row).Error; err != nil {
+		t.Fatalf("failed to create record: %v", err)
+	}
+
+	// A concurrent writer bumps the version after our copy was loaded
+	stale := row
+	if err := db.Model(&row).Update("version", 2).Error; err != nil {
+		t.Fatalf("failed to bump version: %v", err)
+	}
+
+	rename := func(attempts *int, alwaysStale bool) func(context.Context) error {
+		return func(txCtx context.Context) error {
+			*attempts++
+			current := stale
+			if !alwaysStale && *attempts > 1 {
+				if err := Current(txCtx).First(&current, row.ID).Error; err != nil {
+					return err
+				}
+			}
+
+			result := Current(txCtx).Model(&versionedModel{}).
+				Where("id = ? AND version = ?", current.ID, current.Version).
+				Updates(map[string]any{"name": "renamed", "version": current.Version + 1})
+			return CheckVersion(result)
+		}
+	}
+
+	t.Run("retries once after a conflict", func(t *testing.T) {
+		var attempts int
+		if err := WithOptimisticRetry(ctx, 3, rename(&attempts, false)); err != nil {
+			t.Fatalf("expected retry to succeed, got %v", err)
+		}
+		if attempts != 2 {
+			t.Errorf("expected 2 attempts, got %d", attempts)
+		}
+
+		var got versionedModel
+		db.First(&got, row.ID)
+		if got.Name != "renamed" || got.Version != 3 {
+			t.Errorf("unexpected row after retry: %+v", got)
+		}
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		var attempts int
+		err := WithOptimisticRetry(ctx, 3, rename(&attempts, true))
+		if !errors.Is(err, ErrVersionConflict) {
+			t.Fatalf("expected ErrVersionConflict, got %v", err)
+		}
+		if attempts != 3 {
+			t.Errorf("expected 3 attempts, got %d", attempts)
+		}
+	})
+
+	t.Run("other errors are not retried", func(t *testing.T) {
+		testErr := errors.New("not a conflict")
+		var attempts int
+		err := WithOptimisticRetry(ctx, 3, func(context.Context) error {
+			attempts++
+			return testErr
+		})
+		if err != testErr || attempts != 1 {
+			t.Errorf("expected one attempt returning the error, got %d attempts and %v", attempts, err)
+		}
+	})
+}
+
+func TestRetryAttempt(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	if _, _, ok := RetryAttempt(ctx); ok {
+		t.Error("expected no attempt outside WithOptimisticRetry")
+	}
+
+	var attempts, maxes []int
+	err := WithOptimisticRetry(ctx, 3, func(txCtx context.Context) error {
+		attempt, max, ok := RetryAttempt(txCtx)
+		if !ok {
+			t.Fatal("expected an attempt inside WithOptimisticRetry")
+		}
+		attempts = append(attempts, attempt)
+		maxes = append(maxes, max)
+		return ErrVersionConflict
+	})
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected ErrVersionConflict, got %v", err)
+	}
+
+	if fmt.Sprint(attempts) != "[1 2 3]" {
+		t.Errorf("expected attempts 1 to 3, got %v", attempts)
+	}
+	if fmt.Sprint(maxes) != "[3 3 3]" {
+		t.Errorf("expected a maximum of 3 on every attempt, got %v", maxes)
+	}
+}
@@ -0,0 +1,34 @@
+package stx
+
+import "context"
+
+// CheckPending is a debugging aid for leaked callbacks. It returns the number
+// of OnSuccess callbacks registered on the transaction in ctx that have
+// neither run nor been discarded by a rollback, and logs a warning through
+// the DB's GORM logger if there are any. Call it once the code is done with
+// the transaction, for example deferred in a handler or at the end of a test:
+// a non-zero result then means the transaction never reached the point where
+// its callbacks run, such as a Begin without CommitIf, or a context abandoned
+// before its cleanup ran. It returns 0 outside a transaction.
+func CheckPending(ctx context.Context) int {
+	stx := fromContext(ctx)
+	if stx == nil || !IsTx(ctx) {
+		return 0
+	}
+
+	stx.mu.RLock()
+	pending := 0
+	if !stx.callbacksDone {
+		for _, callback := range stx.callbacks {
+			if callback != nil {
+				pending++
+			}
+		}
+	}
+	stx.mu.RUnlock()
+
+	if db := stx.resolveDB(ctx); pending > 0 && db != nil && db.Logger != nil {
+		db.Logger.Warn(ctx, "stx: %d OnSuccess callbacks registered on the transaction have not fired%s", pending, stx.tagSuffix())
+	}
+	return pending
+}
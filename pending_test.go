@@ -0,0 +1,77 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestCheckPending(t *testing.T) {
+	log := &capturingLogger{}
+	db := setupTestDB(t).Session(&gorm.Session{Logger: log})
+	ctx := New(context.Background(), db)
+
+	t.Run("abandoned transaction", func(t *testing.T) {
+		log.warnings = nil
+		txCtx := Begin(ctx)
+		defer Rollback(txCtx)
+
+		OnSuccess(txCtx, func() {})
+		if n := CheckPending(txCtx); n != 1 {
+			t.Errorf("expected one pending callback, got %d", n)
+		}
+		if len(log.warnings) != 1 || !strings.Contains(log.warnings[0], "have not fired") {
+			t.Errorf("expected a warning, got %v", log.warnings)
+		}
+	})
+
+	t.Run("fired callbacks", func(t *testing.T) {
+		log.warnings = nil
+		txCtx := Begin(ctx)
+		OnSuccess(txCtx, func() {})
+		if err := CommitIf(txCtx, func() bool { return true }); err != nil {
+			t.Fatalf("commit failed: %v", err)
+		}
+		if n := CheckPending(txCtx); n != 0 {
+			t.Errorf("expected no pending callbacks after commit, got %d", n)
+		}
+
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			OnSuccess(txCtx, func() {})
+			defer func() {
+				if n := CheckPending(txCtx); n != 1 {
+					t.Errorf("expected the callback to be pending before commit, got %d", n)
+				}
+			}()
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+	})
+
+	t.Run("rolled back", func(t *testing.T) {
+		log.warnings = nil
+		var txCtx context.Context
+		WithTransaction(ctx, func(c context.Context) error {
+			txCtx = c
+			OnSuccess(c, func() {})
+			return errors.New("failed")
+		})
+		if n := CheckPending(txCtx); n != 0 {
+			t.Errorf("expected discarded callbacks not to be pending, got %d", n)
+		}
+		if len(log.warnings) != 0 {
+			t.Errorf("expected no warnings, got %v", log.warnings)
+		}
+	})
+
+	t.Run("outside a transaction", func(t *testing.T) {
+		if n := CheckPending(ctx); n != 0 {
+			t.Errorf("expected 0 outside a transaction, got %d", n)
+		}
+	})
+}
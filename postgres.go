@@ -0,0 +1,111 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	statementTimeoutContextKey    contextKey = "stx:statement_timeout"
+	deferredConstraintsContextKey contextKey = "stx:deferred_constraints"
+)
+
+// isPostgres reports whether db talks to PostgreSQL
+func isPostgres(db *gorm.DB) bool {
+	return db.Dialector != nil && db.Dialector.Name() == "postgres"
+}
+
+// WithStatementTimeout returns a context whose transactions abort individual
+// statements running longer than d. On PostgreSQL this issues
+// SET LOCAL statement_timeout at the start of each transaction, so the limit
+// ends with the transaction. If the statement fails, the transaction is
+// rolled back before any work runs in it and the error is returned. Other
+// drivers have no per-statement equivalent and the setting is a no-op there;
+// use a context deadline on the queries instead.
+func WithStatementTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, statementTimeoutContextKey, d)
+}
+
+// applyStatementTimeout sets the statement timeout configured on the context
+// for the transaction
+func applyStatementTimeout(ctx context.Context, tx *gorm.DB) error {
+	d, _ := ctx.Value(statementTimeoutContextKey).(time.Duration)
+	if d <= 0 || !isPostgres(tx) {
+		return nil
+	}
+
+	// SET does not accept bind parameters
+	return tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", d.Milliseconds())).Error
+}
+
+// WithDeferredConstraints returns a context whose transactions check
+// deferrable constraints only at commit. On PostgreSQL this issues
+// SET CONSTRAINTS ALL DEFERRED at the start of each transaction, which lets
+// it insert rows that reference each other through DEFERRABLE foreign keys.
+// Constraints not declared DEFERRABLE are still checked immediately. Like
+// WithStatementTimeout, a failing statement aborts the transaction before any
+// work runs in it. On other drivers the setting is a no-op.
+func WithDeferredConstraints(ctx context.Context) context.Context {
+	return context.WithValue(ctx, deferredConstraintsContextKey, true)
+}
+
+// applyDeferredConstraints defers constraint checking for the transaction if
+// the context asks for it
+func applyDeferredConstraints(ctx context.Context, tx *gorm.DB) error {
+	deferred, _ := ctx.Value(deferredConstraintsContextKey).(bool)
+	if !deferred || !isPostgres(tx) {
+		return nil
+	}
+	return tx.Exec("SET CONSTRAINTS ALL DEFERRED").Error
+}
+
+// ErrAdvisoryLockUnsupported is returned by AdvisoryLock on drivers other
+// than PostgreSQL.
+var ErrAdvisoryLockUnsupported = errors.New("stx: advisory locks require PostgreSQL")
+
+// AdvisoryLock takes the PostgreSQL transaction-level advisory lock key with
+// pg_advisory_xact_lock, waiting until no other transaction holds it. The lock
+// is released when the outermost transaction ends; there is no explicit
+// unlock. It returns gorm.ErrInvalidTransaction outside a transaction, where
+// the lock would be released immediately, and ErrAdvisoryLockUnsupported on
+// other drivers.
+func AdvisoryLock(ctx context.Context, key int64) error {
+	if !IsTx(ctx) {
+		return gorm.ErrInvalidTransaction
+	}
+
+	tx := Current(ctx)
+	if !isPostgres(tx) {
+		return ErrAdvisoryLockUnsupported
+	}
+	return tx.Exec("SELECT pg_advisory_xact_lock(?)", key).Error
+}
+
+// WithCriticalSection runs fn in a transaction, or a savepoint of the one in
+// ctx, that first takes the advisory lock key, so critical sections using the
+// same key run one at a time across connections and processes. Changing the
+// isolation level midway through a transaction is not portable; serializing
+// on a lock gives the steps in fn stricter guarantees without it. The lock is
+// held until the outermost transaction ends.
+//
+// Advisory locks are PostgreSQL only. On other drivers fn runs in the
+// transaction without a lock, and callers needing mutual exclusion there must
+// lock the rows involved, for example with SELECT ... FOR UPDATE on MySQL.
+func WithCriticalSection(ctx context.Context, key int64, fn func(context.Context) error) error {
+	if fn == nil {
+		return ErrNilFunc
+	}
+
+	return withTransaction(ctx, func(txCtx context.Context) error {
+		if isPostgres(Current(txCtx)) {
+			if err := AdvisoryLock(txCtx, key); err != nil {
+				return err
+			}
+		}
+		return fn(txCtx)
+	}, false)
+}
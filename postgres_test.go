@@ -0,0 +1,309 @@
+package stx
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// postgresNamedDialector reports itself as PostgreSQL so Postgres-only
+// statements can be observed without a Postgres server
+type postgresNamedDialector struct {
+	gorm.Dialector
+}
+
+func (postgresNamedDialector) Name() string {
+	return "postgres"
+}
+
+// setupPostgresNamedDB returns a dry-run DB whose dialector claims to be
+// PostgreSQL: transactions begin for real on SQLite, but statements are only
+// built and logged, never executed
+func setupPostgresNamedDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(postgresNamedDialector{sqlite.Open(testDSN())}, &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+		DryRun: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to connect database: %v", err)
+	}
+	return db
+}
+
+// setupPostgresDB connects to the PostgreSQL server named by the
+// STX_POSTGRES_DSN environment variable, skipping the test when it is unset
+func setupPostgresDB(t *testing.T) *gorm.DB {
+	dsn := os.Getenv("STX_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("STX_POSTGRES_DSN not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to connect database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get database: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// execPostgres runs the statements on db, failing the test on error
+func execPostgres(t *testing.T, db *gorm.DB, statements ...string) {
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatalf("failed to run %q: %v", statement, err)
+		}
+	}
+}
+
+func TestWithStatementTimeout(t *testing.T) {
+	t.Run("postgres issues SET LOCAL", func(t *testing.T) {
+		db := setupPostgresNamedDB(t)
+		ctx := WithQueryLog(WithStatementTimeout(New(context.Background(), db), 250*time.Millisecond))
+
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		statements := QueryLog(ctx)
+		if len(statements) != 1 || statements[0] != "SET LOCAL statement_timeout = 250" {
+			t.Errorf("expected statement timeout to be set, got %v", statements)
+		}
+	})
+
+	t.Run("failure aborts before fn runs", func(t *testing.T) {
+		// SQLite rejects the SET statement the PostgreSQL name triggers
+		db, pool := setupPoolTestDB(t)
+		db = db.Session(&gorm.Session{})
+		db.Dialector = postgresNamedDialector{db.Dialector}
+		ctx := WithStatementTimeout(New(context.Background(), db), time.Second)
+
+		ran := false
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			ran = true
+			return nil
+		})
+		if err == nil {
+			t.Error("expected WithTransaction to fail")
+		}
+		if ran {
+			t.Error("expected fn not to run on a transaction that failed to configure")
+		}
+
+		txCtx := Begin(ctx)
+		if BeginError(txCtx) == nil {
+			t.Error("expected Begin to report the failure")
+		}
+		if IsTx(txCtx) {
+			t.Error("expected no usable transaction")
+		}
+		if n := atomic.LoadInt64(&pool.rolledBack); n != 2 {
+			t.Errorf("expected both transactions to be rolled back, got %d", n)
+		}
+	})
+
+	t.Run("statement aborted on PostgreSQL", func(t *testing.T) {
+		ctx := WithStatementTimeout(New(context.Background(), setupPostgresDB(t)), 50*time.Millisecond)
+
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			return Current(txCtx).Exec("SELECT pg_sleep(1)").Error
+		})
+		if err == nil {
+			t.Error("expected the statement to be canceled by the timeout")
+		}
+
+		// The limit ends with the transaction
+		if err := Current(ctx).Exec("SELECT pg_sleep(0.1)").Error; err != nil {
+			t.Errorf("expected no timeout outside the transaction, got %v", err)
+		}
+	})
+
+	t.Run("no-op on other drivers", func(t *testing.T) {
+		db := setupTestDB(t)
+		ctx := WithQueryLog(WithStatementTimeout(New(context.Background(), db), time.Second))
+
+		txCtx := Begin(ctx)
+		defer Rollback(txCtx)
+
+		if statements := QueryLog(ctx); len(statements) != 0 {
+			t.Errorf("expected no statements on SQLite, got %v", statements)
+		}
+	})
+}
+
+func TestWithDeferredConstraints(t *testing.T) {
+	t.Run("postgres issues SET CONSTRAINTS", func(t *testing.T) {
+		db := setupPostgresNamedDB(t)
+		ctx := WithQueryLog(WithDeferredConstraints(New(context.Background(), db)))
+
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			return Current(txCtx).Exec("INSERT INTO parents (id, child_id) VALUES (1, 1)").Error
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		statements := QueryLog(ctx)
+		if len(statements) != 2 || statements[0] != "SET CONSTRAINTS ALL DEFERRED" {
+			t.Errorf("expected constraints to be deferred first, got %v", statements)
+		}
+	})
+
+	t.Run("circular foreign keys on PostgreSQL", func(t *testing.T) {
+		db := setupPostgresDB(t)
+		execPostgres(t, db,
+			"DROP TABLE IF EXISTS stx_deferred_parents, stx_deferred_children",
+			"CREATE TABLE stx_deferred_parents (id int PRIMARY KEY, child_id int NOT NULL)",
+			"CREATE TABLE stx_deferred_children (id int PRIMARY KEY, parent_id int NOT NULL REFERENCES stx_deferred_parents (id) DEFERRABLE)",
+			"ALTER TABLE stx_deferred_parents ADD FOREIGN KEY (child_id) REFERENCES stx_deferred_children (id) DEFERRABLE",
+		)
+		t.Cleanup(func() { db.Exec("DROP TABLE IF EXISTS stx_deferred_parents, stx_deferred_children") })
+
+		insert := func(id int) func(context.Context) error {
+			return func(txCtx context.Context) error {
+				if err := Current(txCtx).Exec("INSERT INTO stx_deferred_parents (id, child_id) VALUES (?, ?)", id, id).Error; err != nil {
+					return err
+				}
+				return Current(txCtx).Exec("INSERT INTO stx_deferred_children (id, parent_id) VALUES (?, ?)", id, id).Error
+			}
+		}
+
+		ctx := New(context.Background(), db)
+		if err := WithTransaction(ctx, insert(1)); err == nil {
+			t.Fatal("expected the circular insert to fail without deferral")
+		}
+		if err := WithTransaction(WithDeferredConstraints(ctx), insert(2)); err != nil {
+			t.Fatalf("expected the circular insert to commit with deferral, got %v", err)
+		}
+
+		var count int64
+		db.Table("stx_deferred_parents").Count(&count)
+		if count != 1 {
+			t.Errorf("expected only the deferred rows to be committed, got %d", count)
+		}
+	})
+
+	t.Run("combined with statement timeout", func(t *testing.T) {
+		db := setupPostgresNamedDB(t)
+		ctx := New(context.Background(), db)
+		ctx = WithQueryLog(WithDeferredConstraints(WithStatementTimeout(ctx, time.Second)))
+
+		txCtx := Begin(ctx)
+		defer Rollback(txCtx)
+
+		statements := QueryLog(ctx)
+		if len(statements) != 2 || statements[1] != "SET CONSTRAINTS ALL DEFERRED" {
+			t.Errorf("expected both statements, got %v", statements)
+		}
+	})
+
+	t.Run("no-op on other drivers", func(t *testing.T) {
+		db := setupTestDB(t)
+		ctx := WithQueryLog(WithDeferredConstraints(New(context.Background(), db)))
+
+		txCtx := Begin(ctx)
+		defer Rollback(txCtx)
+
+		if statements := QueryLog(ctx); len(statements) != 0 {
+			t.Errorf("expected no statements on SQLite, got %v", statements)
+		}
+	})
+}
+
+func TestAdvisoryLock(t *testing.T) {
+	t.Run("postgres takes the lock", func(t *testing.T) {
+		db := setupPostgresNamedDB(t)
+		ctx := WithQueryLog(New(context.Background(), db))
+
+		var ran bool
+		err := WithCriticalSection(ctx, 42, func(txCtx context.Context) error {
+			ran = true
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("critical section failed: %v", err)
+		}
+		if !ran {
+			t.Error("expected critical section to run")
+		}
+
+		statements := QueryLog(ctx)
+		if len(statements) != 1 || statements[0] != "SELECT pg_advisory_xact_lock(42)" {
+			t.Errorf("expected advisory lock, got %v", statements)
+		}
+	})
+
+	t.Run("critical sections do not overlap on PostgreSQL", func(t *testing.T) {
+		ctx := New(context.Background(), setupPostgresDB(t))
+
+		var inside, overlaps int32
+		var wg sync.WaitGroup
+		errs := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- WithCriticalSection(ctx, 4242, func(txCtx context.Context) error {
+					if atomic.AddInt32(&inside, 1) > 1 {
+						atomic.AddInt32(&overlaps, 1)
+					}
+					time.Sleep(100 * time.Millisecond)
+					atomic.AddInt32(&inside, -1)
+					return nil
+				})
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				t.Fatalf("critical section failed: %v", err)
+			}
+		}
+		if n := atomic.LoadInt32(&overlaps); n != 0 {
+			t.Errorf("expected critical sections to run one at a time, got %d overlaps", n)
+		}
+	})
+
+	t.Run("outside a transaction", func(t *testing.T) {
+		ctx := New(context.Background(), setupPostgresNamedDB(t))
+		if err := AdvisoryLock(ctx, 1); err != gorm.ErrInvalidTransaction {
+			t.Errorf("expected ErrInvalidTransaction, got %v", err)
+		}
+	})
+
+	t.Run("other drivers", func(t *testing.T) {
+		db := setupTestDB(t)
+		ctx := WithQueryLog(New(context.Background(), db))
+
+		err := WithCriticalSection(ctx, 42, func(txCtx context.Context) error {
+			if err := AdvisoryLock(txCtx, 42); err != ErrAdvisoryLockUnsupported {
+				t.Errorf("expected ErrAdvisoryLockUnsupported, got %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("critical section failed: %v", err)
+		}
+		if statements := QueryLog(ctx); len(statements) != 0 {
+			t.Errorf("expected no lock statement on SQLite, got %v", statements)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package stx
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+const preparedStatementsContextKey contextKey = "stx:prepared_statements"
+
+// WithPreparedStatements returns a context whose top-level transactions
+// prepare and cache their statements, as with gorm.Session{PrepareStmt: true},
+// without enabling statement preparation for the whole DB. Repeated queries
+// within such a transaction reuse the prepared statement, which speeds up hot
+// paths issuing the same statements many times. Nested
+// transactions inherit the setting from the enclosing one.
+//
+// The setting has no effect together with WithDedicatedConn, as statements
+// prepared on the pool cannot be reused on a connection that is discarded
+// afterwards.
+func WithPreparedStatements(ctx context.Context) context.Context {
+	return context.WithValue(ctx, preparedStatementsContextKey, true)
+}
+
+// preparedDB returns db with statement preparation enabled if ctx asks for
+// it. It must be applied before the transaction begins, as a transactional
+// session only prepares statements if it begins through GORM's prepared
+// statement pool.
+func preparedDB(ctx context.Context, db *gorm.DB) *gorm.DB {
+	prepared, _ := ctx.Value(preparedStatementsContextKey).(bool)
+	dedicated, _ := ctx.Value(dedicatedConnContextKey).(bool)
+	if !prepared || dedicated || IsTx(ctx) {
+		return db
+	}
+	return db.Session(&gorm.Session{PrepareStmt: true})
+}
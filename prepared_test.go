@@ -0,0 +1,53 @@
+package stx
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWithPreparedStatements(t *testing.T) {
+	db, pool := setupPoolTestDB(t)
+	ctx := New(context.Background(), db)
+
+	query := func(txCtx context.Context) error {
+		for i := 0; i < 3; i++ {
+			var count int64
+			if err := Current(txCtx).Model(&TestModel{}).Where("name = ?", i).Count(&count).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := WithTransaction(ctx, query); err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+	if n := atomic.LoadInt64(&pool.prepared); n != 0 {
+		t.Fatalf("expected no prepared statements by default, got %d", n)
+	}
+
+	err := WithTransaction(WithPreparedStatements(ctx), func(txCtx context.Context) error {
+		if !IsTx(txCtx) {
+			t.Error("expected prepared session to stay transactional")
+		}
+		return query(txCtx)
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+	if n := atomic.LoadInt64(&pool.prepared); n != 1 {
+		t.Errorf("expected the repeated query to be prepared once, got %d", n)
+	}
+
+	txCtx := Begin(WithPreparedStatements(ctx))
+	if !IsTx(txCtx) {
+		t.Error("expected prepared session begun with Begin to be transactional")
+	}
+	if err := query(txCtx); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if err := Commit(txCtx); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package stx
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Query runs a raw SQL query on the current database of ctx and scans the
+// rows into a slice of T, which may be a struct or a single column type:
+//
+//	type Total struct {
+//	    Name  string
+//	    Count int
+//	}
+//	totals, err := stx.Query[Total](ctx, "SELECT name, COUNT(*) AS count FROM orders GROUP BY name")
+//
+// Within a transaction the query runs on it and sees its uncommitted changes.
+// Query returns gorm.ErrInvalidTransaction if ctx holds no database.
+func Query[T any](ctx context.Context, sql string, args ...any) ([]T, error) {
+	db := Current(ctx)
+	if db == nil {
+		return nil, gorm.ErrInvalidTransaction
+	}
+
+	var results []T
+	if err := db.Raw(sql, args...).Scan(&results).Error; err != nil {
+		return nil, err
+	}
+	return results, nil
+}
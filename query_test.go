@@ -0,0 +1,55 @@
+package stx
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestQuery(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	t.Run("sees uncommitted rows", func(t *testing.T) {
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			for _, name := range []string{"alice", "bob"} {
+				if err := Current(txCtx).Create(&TestModel{Name: name}).Error; err != nil {
+					return err
+				}
+			}
+
+			models, err := Query[TestModel](txCtx, "SELECT * FROM test_models WHERE name IN (?) ORDER BY name", []string{"alice", "bob"})
+			if err != nil {
+				return err
+			}
+			if len(models) != 2 || models[0].Name != "alice" || models[1].Name != "bob" {
+				t.Errorf("expected alice and bob, got %+v", models)
+			}
+
+			names, err := Query[string](txCtx, "SELECT name FROM test_models ORDER BY name")
+			if err != nil {
+				return err
+			}
+			if len(names) != 2 || names[0] != "alice" {
+				t.Errorf("expected scanned names, got %v", names)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		if _, err := Query[TestModel](ctx, "SELECT * FROM missing_table"); err == nil {
+			t.Error("expected error for missing table")
+		}
+	})
+
+	t.Run("no database", func(t *testing.T) {
+		if _, err := Query[TestModel](context.Background(), "SELECT 1"); err != gorm.ErrInvalidTransaction {
+			t.Errorf("expected ErrInvalidTransaction, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+var (
+	queryErrorOwnersMu sync.RWMutex
+	// queryErrorOwners maps the connection pool of each running top-level
+	// transaction to the STX collecting its query errors
+	queryErrorOwners = make(map[gorm.ConnPool]*STX)
+	// queryErrorCollectors records the GORM callback chains the collector
+	// has been registered on, keyed by *gorm.callbacks, which a DB shares
+	// with its sessions and transactions. queryErrorCollectorsMu serializes
+	// registrations.
+	queryErrorCollectors   sync.Map
+	queryErrorCollectorsMu sync.Mutex
+)
+
+// EnableQueryErrors registers the GORM callbacks named "stx:query_errors",
+// which collect the statement errors reported by QueryErrors and LastError,
+// on db and the sessions and transactions derived from it. Collection is opt-in, as the
+// callbacks run for every statement on db. Call EnableQueryErrors once during
+// setup, before db serves queries: GORM does not synchronize registering
+// callbacks with statements running concurrently. Calling it again for the
+// same DB does nothing.
+func EnableQueryErrors(db *gorm.DB) error {
+	if db == nil {
+		return gorm.ErrInvalidDB
+	}
+
+	queryErrorCollectorsMu.Lock()
+	defer queryErrorCollectorsMu.Unlock()
+
+	callbacks := db.Callback()
+	if _, ok := queryErrorCollectors.Load(callbacks); ok {
+		return nil
+	}
+
+	registers := []func(name string, fn func(*gorm.DB)) error{
+		callbacks.Create().After("*").Register,
+		callbacks.Query().After("*").Register,
+		callbacks.Update().After("*").Register,
+		callbacks.Delete().After("*").Register,
+		callbacks.Row().After("*").Register,
+		callbacks.Raw().After("*").Register,
+	}
+	for _, register := range registers {
+		if err := register("stx:query_errors", collectQueryError); err != nil {
+			return err
+		}
+	}
+	queryErrorCollectors.Store(callbacks, true)
+	return nil
+}
+
+// QueryErrors returns the errors of all statements that failed in the
+// transaction in ctx so far, in order, including the ones handled by the
+// code and not returned. This helps diagnose transactions where several
+// queries failed, not just the one that caused the rollback. Errors are
+// collected for the whole database transaction, so a nested transaction
+// reports those of the enclosing one as well. gorm.ErrRecordNotFound is not
+// collected. QueryErrors keeps working on the context after the transaction
+// has ended, and returns nil outside a transaction.
+//
+// Errors are only collected on DBs set up with EnableQueryErrors; elsewhere
+// QueryErrors always returns nil.
+func QueryErrors(ctx context.Context) []error {
+	stx := fromContext(ctx)
+	if stx == nil || stx.depth == 0 {
+		return nil
+	}
+
+	root := stx.outermost()
+	root.mu.RLock()
+	defer root.mu.RUnlock()
+	if len(root.queryErrs) == 0 {
+		return nil
+	}
+	errs := make([]error, len(root.queryErrs))
+	copy(errs, root.queryErrs)
+	return errs
+}
+
+// LastError returns the error of the most recent GORM operation in the
+// transaction in ctx, or nil if it succeeded, including
+// gorm.ErrRecordNotFound. Like QueryErrors it covers the whole database
+// transaction, keeps working after the transaction has ended and needs the
+// DB to be set up with EnableQueryErrors; without it LastError always returns
+// nil. It also returns nil outside a transaction and before the first
+// operation.
+func LastError(ctx context.Context) error {
+	stx := fromContext(ctx)
+	if stx == nil || stx.depth == 0 {
+		return nil
+	}
+
+	root := stx.outermost()
+	root.mu.RLock()
+	defer root.mu.RUnlock()
+	return root.lastErr
+}
+
+// outermost returns the STX of the top-level transaction stx is part of
+func (stx *STX) outermost() *STX {
+	root := stx
+	for root.depth > 1 && root.parent != nil {
+		root = root.parent
+	}
+	return root
+}
+
+// trackQueryErrors starts collecting the query errors of the top-level
+// transaction stx, if its DB was set up with EnableQueryErrors
+func (stx *STX) trackQueryErrors() {
+	if stx.db == nil || stx.db.Statement.ConnPool == nil {
+		return
+	}
+	if _, ok := queryErrorCollectors.Load(stx.db.Callback()); !ok {
+		return
+	}
+
+	queryErrorOwnersMu.Lock()
+	queryErrorOwners[stx.db.Statement.ConnPool] = stx
+	queryErrorOwnersMu.Unlock()
+}
+
+// untrackQueryErrors stops collecting query errors for stx. It is safe to
+// call for transactions that are not tracked.
+func (stx *STX) untrackQueryErrors() {
+	if stx.db == nil || stx.depth != 1 {
+		return
+	}
+
+	pool := stx.db.Statement.ConnPool
+	queryErrorOwnersMu.Lock()
+	if queryErrorOwners[pool] == stx {
+		delete(queryErrorOwners, pool)
+	}
+	queryErrorOwnersMu.Unlock()
+}
+
+// collectQueryError records the error of the statement db just executed on
+// the transaction it belongs to
+func collectQueryError(db *gorm.DB) {
+	queryErrorOwnersMu.RLock()
+	stx := queryErrorOwners[db.Statement.ConnPool]
+	queryErrorOwnersMu.RUnlock()
+	if stx == nil {
+		return
+	}
+
+	stx.mu.Lock()
+	stx.lastErr = db.Error
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		stx.queryErrs = append(stx.queryErrs, db.Error)
+	}
+	stx.mu.Unlock()
+}
@@ -0,0 +1,159 @@
+package stx
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestQueryErrors(t *testing.T) {
+	db := setupTestDB(t)
+	if err := EnableQueryErrors(db); err != nil {
+		t.Fatalf("failed to enable query errors: %v", err)
+	}
+	ctx := New(context.Background(), db)
+
+	if err := db.Create(&TestModel{ID: 1, Name: "existing"}).Error; err != nil {
+		t.Fatalf("failed to create record: %v", err)
+	}
+
+	t.Run("collects every failure", func(t *testing.T) {
+		var txCtx context.Context
+		err := WithTransaction(ctx, func(c context.Context) error {
+			txCtx = c
+			// Handled failure: the code carries on
+			Current(c).Exec("INSERT INTO missing_table VALUES (1)")
+
+			var model TestModel
+			if err := Current(c).First(&model, 999).Error; err == nil {
+				t.Error("expected record not found")
+			}
+
+			return WithTransaction(c, func(nestedCtx context.Context) error {
+				return Current(nestedCtx).Create(&TestModel{ID: 1, Name: "duplicate"}).Error
+			})
+		})
+		if err == nil {
+			t.Fatal("expected the duplicate to fail the transaction")
+		}
+
+		errs := QueryErrors(txCtx)
+		if len(errs) != 2 {
+			t.Fatalf("expected two query errors, got %v", errs)
+		}
+		if !strings.Contains(errs[0].Error(), "no such table") {
+			t.Errorf("expected the missing table first, got %v", errs[0])
+		}
+		if !IsUniqueViolation(errs[1]) {
+			t.Errorf("expected the unique violation second, got %v", errs[1])
+		}
+	})
+
+	t.Run("separate transactions", func(t *testing.T) {
+		txCtx := Begin(ctx)
+		defer Rollback(txCtx)
+		if errs := QueryErrors(txCtx); errs != nil {
+			t.Errorf("expected no errors in a fresh transaction, got %v", errs)
+		}
+
+		Current(ctx).Exec("SELECT * FROM missing_table")
+		if errs := QueryErrors(txCtx); errs != nil {
+			t.Errorf("expected errors outside the transaction to be ignored, got %v", errs)
+		}
+
+		Current(txCtx).Exec("SELECT * FROM missing_table")
+		if errs := QueryErrors(txCtx); len(errs) != 1 {
+			t.Errorf("expected one error, got %v", errs)
+		}
+	})
+
+	t.Run("outside a transaction", func(t *testing.T) {
+		if errs := QueryErrors(ctx); errs != nil {
+			t.Errorf("expected nil outside a transaction, got %v", errs)
+		}
+	})
+
+	t.Run("after rollback", func(t *testing.T) {
+		txCtx := Begin(ctx)
+		Current(txCtx).Exec("SELECT * FROM missing_table")
+		if err := Rollback(txCtx); err != nil {
+			t.Fatalf("rollback failed: %v", err)
+		}
+		if errs := QueryErrors(txCtx); len(errs) != 1 {
+			t.Errorf("expected the error to remain available, got %v", errs)
+		}
+	})
+}
+
+func TestQueryErrorsOptIn(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	var txCtx context.Context
+	WithTransaction(ctx, func(c context.Context) error {
+		txCtx = c
+		return Current(c).Exec("INSERT INTO missing_table VALUES (1)").Error
+	})
+
+	if db.Callback().Query().Get("stx:query_errors") != nil {
+		t.Error("expected beginning a transaction not to register callbacks")
+	}
+	if errs := QueryErrors(txCtx); errs != nil {
+		t.Errorf("expected no errors without EnableQueryErrors, got %v", errs)
+	}
+	if err := LastError(txCtx); err != nil {
+		t.Errorf("expected no last error without EnableQueryErrors, got %v", err)
+	}
+
+	if err := EnableQueryErrors(db); err != nil {
+		t.Fatalf("failed to enable query errors: %v", err)
+	}
+	if err := EnableQueryErrors(db.Session(&gorm.Session{})); err != nil {
+		t.Fatalf("enabling a session of the same DB again failed: %v", err)
+	}
+	if db.Callback().Query().Get("stx:query_errors") == nil {
+		t.Error("expected EnableQueryErrors to register the callbacks")
+	}
+}
+
+func TestLastError(t *testing.T) {
+	db := setupTestDB(t)
+	if err := EnableQueryErrors(db); err != nil {
+		t.Fatalf("failed to enable query errors: %v", err)
+	}
+	ctx := New(context.Background(), db)
+
+	txCtx := Begin(ctx)
+	defer Rollback(txCtx)
+
+	if err := LastError(txCtx); err != nil {
+		t.Errorf("expected nil before the first operation, got %v", err)
+	}
+
+	Current(txCtx).Exec("INSERT INTO missing_table VALUES (1)")
+	if err := LastError(txCtx); err == nil || !strings.Contains(err.Error(), "no such table") {
+		t.Errorf("expected the failed insert, got %v", err)
+	}
+
+	var model TestModel
+	Current(txCtx).First(&model, 999)
+	if err := LastError(txCtx); err != gorm.ErrRecordNotFound {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	err := WithTransaction(txCtx, func(nestedCtx context.Context) error {
+		return Current(nestedCtx).Create(&TestModel{Name: "last"}).Error
+	})
+	if err != nil {
+		t.Fatalf("nested transaction failed: %v", err)
+	}
+	if err := LastError(txCtx); err != nil {
+		t.Errorf("expected the succeeding operation to clear the error, got %v", err)
+	}
+
+	if err := LastError(ctx); err != nil {
+		t.Errorf("expected nil outside a transaction, got %v", err)
+	}
+}
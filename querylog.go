@@ -0,0 +1,72 @@
+package stx
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+const queryLogContextKey contextKey = "stx:query_log"
+
+// queryLog collects the SQL executed by transactions in a WithQueryLog scope
+type queryLog struct {
+	mu         sync.Mutex
+	statements []string
+}
+
+// queryLogger is a GORM logger that records every traced statement into a
+// queryLog before passing it on to the DB's original logger
+type queryLogger struct {
+	wrappedLogger
+	log *queryLog
+}
+
+func (l *queryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &queryLogger{wrappedLogger: wrappedLogger{next: l.next.LogMode(level)}, log: l.log}
+}
+
+func (l *queryLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	sql, _ := fc()
+	l.log.mu.Lock()
+	l.log.statements = append(l.log.statements, sql)
+	l.log.mu.Unlock()
+
+	l.next.Trace(ctx, begin, fc, err)
+}
+
+// WithQueryLog returns a context whose transactions record the SQL they
+// execute, regardless of the DB's configured log level. The statements are
+// available from QueryLog on the returned context, also after the transaction
+// has finished. Only transactional DBs started from the returned context are
+// affected; queries outside a transaction are not captured.
+func WithQueryLog(ctx context.Context) context.Context {
+	return context.WithValue(ctx, queryLogContextKey, &queryLog{})
+}
+
+// QueryLog returns the SQL statements captured in the WithQueryLog scope of
+// the context, in execution order. It returns nil if query logging is not
+// enabled.
+func QueryLog(ctx context.Context) []string {
+	log := queryLogFromContext(ctx)
+	if log == nil {
+		return nil
+	}
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	statements := make([]string, len(log.statements))
+	copy(statements, log.statements)
+	return statements
+}
+
+// queryLogFromContext returns the query log stored with WithQueryLog
+func queryLogFromContext(ctx context.Context) *queryLog {
+	if ctx == nil {
+		return nil
+	}
+
+	log, _ := ctx.Value(queryLogContextKey).(*queryLog)
+	return log
+}
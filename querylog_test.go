@@ -0,0 +1,50 @@
+package stx
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestQueryLog(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+	logCtx := WithQueryLog(ctx)
+
+	err := WithTransaction(logCtx, func(txCtx context.Context) error {
+		model := TestModel{Name: "query-log-test"}
+		if err := Current(txCtx).Create(&model).Error; err != nil {
+			return err
+		}
+
+		return WithTransaction(txCtx, func(innerCtx context.Context) error {
+			var count int64
+			return Current(innerCtx).Model(&TestModel{}).Count(&count).Error
+		})
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+
+	// Queries outside the transaction are not captured
+	var count int64
+	Current(logCtx).Model(&TestModel{}).Count(&count)
+
+	statements := QueryLog(logCtx)
+	if len(statements) != 3 {
+		t.Fatalf("expected 3 captured statements, got %d: %v", len(statements), statements)
+	}
+	if !strings.HasPrefix(statements[0], "INSERT INTO `test_models`") || !strings.Contains(statements[0], "query-log-test") {
+		t.Errorf("expected insert statement, got %q", statements[0])
+	}
+	if !strings.HasPrefix(statements[1], "SAVEPOINT ") {
+		t.Errorf("expected savepoint statement for nested transaction, got %q", statements[1])
+	}
+	if !strings.HasPrefix(statements[2], "SELECT count(*) FROM `test_models`") {
+		t.Errorf("expected count statement, got %q", statements[2])
+	}
+
+	if QueryLog(ctx) != nil {
+		t.Error("expected no query log outside a WithQueryLog scope")
+	}
+}
@@ -0,0 +1,42 @@
+package stx
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Repo wraps a context for repository structs that hold one, so they can
+// embed it instead of calling the package functions with their context:
+//
+//	type UserRepo struct {
+//	    stx.Repo
+//	}
+//
+//	func (r UserRepo) Create(u *User) error {
+//	    r.OnSuccess(func() { cache.Invalidate("users") })
+//	    return r.DB().Create(u).Error
+//	}
+type Repo struct {
+	Ctx context.Context
+}
+
+// NewRepo returns a Repo for ctx
+func NewRepo(ctx context.Context) Repo {
+	return Repo{Ctx: ctx}
+}
+
+// DB returns the current database for the repository's context, see Current
+func (r Repo) DB() *gorm.DB {
+	return Current(r.Ctx)
+}
+
+// InTx reports whether the repository's context is in a transaction, see IsTx
+func (r Repo) InTx() bool {
+	return IsTx(r.Ctx)
+}
+
+// OnSuccess registers a callback on the repository's context, see OnSuccess
+func (r Repo) OnSuccess(callback func()) {
+	OnSuccess(r.Ctx, callback)
+}
@@ -0,0 +1,52 @@
+package stx
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRepo(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	t.Run("transactional context", func(t *testing.T) {
+		var called bool
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			repo := NewRepo(txCtx)
+			if !repo.InTx() {
+				t.Error("expected InTx to return true")
+			}
+			if repo.DB() != Current(txCtx) {
+				t.Error("expected DB to return the transaction DB")
+			}
+
+			repo.OnSuccess(func() { called = true })
+			if called {
+				t.Error("expected callback to wait for the commit")
+			}
+			return repo.DB().Create(&TestModel{Name: "repo"}).Error
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if !called {
+			t.Error("expected callback to run after the commit")
+		}
+	})
+
+	t.Run("non-transactional context", func(t *testing.T) {
+		repo := NewRepo(ctx)
+		if repo.InTx() {
+			t.Error("expected InTx to return false")
+		}
+		if repo.DB() != db {
+			t.Error("expected DB to return the root DB")
+		}
+
+		var called bool
+		repo.OnSuccess(func() { called = true })
+		if !called {
+			t.Error("expected callback to run immediately")
+		}
+	})
+}
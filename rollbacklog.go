@@ -0,0 +1,85 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const rollbackSQLLogContextKey contextKey = "stx:rollback_sql_log"
+
+// failedStatement holds the last statement that failed in a
+// WithRollbackSQLLog scope
+type failedStatement struct {
+	mu  sync.Mutex
+	sql string
+	err error
+}
+
+// take returns the recorded statement and clears it
+func (f *failedStatement) take() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sql, err := f.sql, f.err
+	f.sql, f.err = "", nil
+	return sql, err
+}
+
+// failureLogger is a GORM logger that records statements failing with an
+// error into a failedStatement before passing them on to the next logger
+type failureLogger struct {
+	wrappedLogger
+	failed *failedStatement
+}
+
+func (l *failureLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &failureLogger{wrappedLogger: wrappedLogger{next: l.next.LogMode(level)}, failed: l.failed}
+}
+
+func (l *failureLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		sql, _ := fc()
+		l.failed.mu.Lock()
+		l.failed.sql, l.failed.err = sql, err
+		l.failed.mu.Unlock()
+	}
+
+	l.next.Trace(ctx, begin, fc, err)
+}
+
+// WithRollbackSQLLog returns a context whose transactions remember the last
+// statement that failed. When such a transaction rolls back, the statement
+// and its error are logged through the DB's GORM logger at error level, so
+// operators can see which query caused the rollback without enabling SQL
+// logging globally. Rollbacks without a failed statement are not logged.
+func WithRollbackSQLLog(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rollbackSQLLogContextKey, &failedStatement{})
+}
+
+// failedStatementFromContext returns the recorder stored with
+// WithRollbackSQLLog
+func failedStatementFromContext(ctx context.Context) *failedStatement {
+	if ctx == nil {
+		return nil
+	}
+
+	failed, _ := ctx.Value(rollbackSQLLogContextKey).(*failedStatement)
+	return failed
+}
+
+// logFailedStatement logs the statement that caused stx to roll back, if one
+// was recorded
+func logFailedStatement(ctx context.Context, db *gorm.DB, stx *STX) {
+	failed := failedStatementFromContext(ctx)
+	if failed == nil || db == nil || db.Logger == nil {
+		return
+	}
+
+	if sql, err := failed.take(); err != nil {
+		db.Logger.Error(ctx, "stx: transaction rolled back after failed statement %q: %v%s", sql, err, stx.tagSuffix())
+	}
+}
@@ -0,0 +1,75 @@
+package stx
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestWithRollbackSQLLog(t *testing.T) {
+	log := &capturingLogger{}
+	db := setupTestDB(t).Session(&gorm.Session{Logger: log})
+	ctx := New(context.Background(), db)
+
+	if err := db.Create(&TestModel{ID: 1, Name: "existing"}).Error; err != nil {
+		t.Fatalf("failed to create record: %v", err)
+	}
+
+	violate := func(txCtx context.Context) error {
+		return Current(txCtx).Create(&TestModel{ID: 1, Name: "duplicate"}).Error
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		if err := WithTransaction(ctx, violate); err == nil {
+			t.Fatal("expected constraint violation")
+		}
+		if len(log.errors) != 0 {
+			t.Errorf("expected no error logs, got %v", log.errors)
+		}
+	})
+
+	t.Run("WithTransaction", func(t *testing.T) {
+		log.errors = nil
+		logCtx := WithRollbackSQLLog(ctx)
+
+		if err := WithTransaction(logCtx, violate); err == nil {
+			t.Fatal("expected constraint violation")
+		}
+		if len(log.errors) != 1 {
+			t.Fatalf("expected one error log, got %v", log.errors)
+		}
+		if !strings.Contains(log.errors[0], "INSERT INTO") || !strings.Contains(log.errors[0], "UNIQUE constraint failed") {
+			t.Errorf("expected log to include the failed SQL and error, got %q", log.errors[0])
+		}
+	})
+
+	t.Run("WithDefer", func(t *testing.T) {
+		log.errors = nil
+		logCtx := WithRollbackSQLLog(ctx)
+
+		err := func() (err error) {
+			txCtx, cleanup := WithDefer(logCtx)
+			defer cleanup(&err)
+			return violate(txCtx)
+		}()
+		if err == nil {
+			t.Fatal("expected constraint violation")
+		}
+		if len(log.errors) != 1 || !strings.Contains(log.errors[0], "INSERT INTO") {
+			t.Errorf("expected the failed SQL to be logged, got %v", log.errors)
+		}
+	})
+
+	t.Run("rollback without failed statement", func(t *testing.T) {
+		log.errors = nil
+		txCtx := Begin(WithRollbackSQLLog(ctx))
+		if err := Rollback(txCtx); err != nil {
+			t.Fatalf("rollback failed: %v", err)
+		}
+		if len(log.errors) != 0 {
+			t.Errorf("expected no error logs, got %v", log.errors)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package stx
+
+import (
+	"context"
+	"sync"
+)
+
+const sagaContextKey contextKey = "stx:saga"
+
+// Saga tracks a sequence of independently committed steps together with the
+// compensating actions that undo them. Each step runs in its own transaction;
+// if a later step fails, Compensate runs the compensations of the steps that
+// already committed, in reverse order.
+type Saga struct {
+	mu            sync.Mutex
+	compensations []func() error
+}
+
+// NewSaga returns a new Saga and a context carrying it, so that
+// AddCompensation calls made with the context are recorded on the saga.
+func NewSaga(ctx context.Context) (context.Context, *Saga) {
+	saga := &Saga{}
+	return context.WithValue(ctx, sagaContextKey, saga), saga
+}
+
+// Step runs fn in a transaction via WithTransaction. Compensations registered
+// with AddCompensation inside fn are only recorded if the step commits.
+func (s *Saga) Step(ctx context.Context, fn func(context.Context) error) error {
+	return withTransaction(context.WithValue(ctx, sagaContextKey, s), fn, false)
+}
+
+// AddCompensation registers a compensating action on the saga carried by the
+// context. Within a transaction the compensation is recorded once the
+// transaction commits, so steps that roll back leave nothing to undo. It does
+// nothing if the context carries no saga.
+func AddCompensation(ctx context.Context, fn func() error) {
+	if ctx == nil || fn == nil {
+		return
+	}
+
+	saga, ok := ctx.Value(sagaContextKey).(*Saga)
+	if !ok || saga == nil {
+		return
+	}
+
+	addCallback(ctx, func() {
+		saga.mu.Lock()
+		saga.compensations = append(saga.compensations, fn)
+		saga.mu.Unlock()
+	})
+}
+
+// Compensate runs the recorded compensations in reverse order of registration
+// and clears them. All compensations run even if some fail; the first error
+// encountered is returned.
+func (s *Saga) Compensate() error {
+	s.mu.Lock()
+	compensations := s.compensations
+	s.compensations = nil
+	s.mu.Unlock()
+
+	var firstErr error
+	for i := len(compensations) - 1; i >= 0; i-- {
+		if err := compensations[i](); err != nil && firstErr == nil {
+			firstErr = newSTXError("compensation failed", err)
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,88 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSaga(t *testing.T) {
+	db := setupTestDB(t)
+	ctx, saga := NewSaga(New(context.Background(), db))
+
+	var compensated []string
+	step := func(name string, fail bool) func(context.Context) error {
+		return func(txCtx context.Context) error {
+			AddCompensation(txCtx, func() error {
+				compensated = append(compensated, name)
+				return nil
+			})
+			model := TestModel{Name: "saga-" + name}
+			if err := Current(txCtx).Create(&model).Error; err != nil {
+				return err
+			}
+			if fail {
+				return errors.New(name + " failed")
+			}
+			return nil
+		}
+	}
+
+	if err := saga.Step(ctx, step("first", false)); err != nil {
+		t.Fatalf("first step failed: %v", err)
+	}
+	if err := saga.Step(ctx, step("second", false)); err != nil {
+		t.Fatalf("second step failed: %v", err)
+	}
+	if err := saga.Step(ctx, step("third", true)); err == nil {
+		t.Fatal("expected third step to fail")
+	}
+
+	if err := saga.Compensate(); err != nil {
+		t.Fatalf("compensate failed: %v", err)
+	}
+
+	expected := []string{"second", "first"}
+	if len(compensated) != len(expected) {
+		t.Fatalf("expected compensations %v, got %v", expected, compensated)
+	}
+	for i := range expected {
+		if compensated[i] != expected[i] {
+			t.Fatalf("expected compensations %v, got %v", expected, compensated)
+		}
+	}
+
+	// Compensations are cleared once they have run
+	compensated = nil
+	if err := saga.Compensate(); err != nil || len(compensated) != 0 {
+		t.Errorf("expected second Compensate to be a no-op, got %v, %v", compensated, err)
+	}
+}
+
+func TestSagaCompensationError(t *testing.T) {
+	db := setupTestDB(t)
+	ctx, saga := NewSaga(New(context.Background(), db))
+	compErr := errors.New("undo failed")
+
+	var ran int
+	for _, err := range []error{nil, compErr} {
+		err := err
+		stepErr := saga.Step(ctx, func(txCtx context.Context) error {
+			AddCompensation(txCtx, func() error {
+				ran++
+				return err
+			})
+			return nil
+		})
+		if stepErr != nil {
+			t.Fatalf("step failed: %v", stepErr)
+		}
+	}
+
+	if err := saga.Compensate(); !errors.Is(err, compErr) {
+		t.Errorf("expected compensation error, got: %v", err)
+	}
+	if ran != 2 {
+		t.Errorf("expected all compensations to run, got %d", ran)
+	}
+}
@@ -0,0 +1,64 @@
+package stx
+
+import (
+	"context"
+	"strings"
+)
+
+// OpenSavepoints returns the names of the savepoints created with Begin or
+// WithDefer in the transaction in ctx that have been neither committed nor
+// rolled back, in creation order. Savepoints that are never ended pile up
+// when code begins a nested transaction and forgets its Commit or Rollback;
+// stx also logs a warning through the DB's GORM logger if any are left when
+// the top-level transaction ends. Savepoints of nested WithTransaction calls
+// are managed by GORM and never listed. It returns nil outside a
+// transaction.
+func OpenSavepoints(ctx context.Context) []string {
+	stx := fromContext(ctx)
+	if stx == nil || !IsTx(ctx) {
+		return nil
+	}
+
+	root := stx.outermost()
+	root.mu.RLock()
+	defer root.mu.RUnlock()
+	if len(root.savepoints) == 0 {
+		return nil
+	}
+	return append([]string(nil), root.savepoints...)
+}
+
+// trackSavepoint records the savepoint of stx as open on its top-level
+// transaction
+func (stx *STX) trackSavepoint() {
+	root := stx.outermost()
+	root.mu.Lock()
+	root.savepoints = append(root.savepoints, stx.savepoint)
+	root.mu.Unlock()
+}
+
+// untrackSavepoint records that the savepoint of stx has ended
+func (stx *STX) untrackSavepoint() {
+	root := stx.outermost()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	for i, name := range root.savepoints {
+		if name == stx.savepoint {
+			root.savepoints = append(root.savepoints[:i], root.savepoints[i+1:]...)
+			return
+		}
+	}
+}
+
+// warnOpenSavepoints logs a warning if the top-level transaction stx ends
+// with savepoints that were never ended
+func (stx *STX) warnOpenSavepoints() {
+	stx.mu.RLock()
+	open := strings.Join(stx.savepoints, ", ")
+	stx.mu.RUnlock()
+
+	if open == "" || stx.db == nil || stx.db.Logger == nil {
+		return
+	}
+	stx.db.Logger.Warn(stx.db.Statement.Context, "stx: transaction ended with savepoints never committed or rolled back: %s%s", open, stx.tagSuffix())
+}
@@ -0,0 +1,45 @@
+package stx
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestOpenSavepoints(t *testing.T) {
+	log := &capturingLogger{}
+	db := setupTestDB(t).Session(&gorm.Session{Logger: log})
+	ctx := New(context.Background(), db)
+
+	if sp := OpenSavepoints(ctx); sp != nil {
+		t.Errorf("expected no savepoints outside a transaction, got %v", sp)
+	}
+
+	txCtx := Begin(ctx)
+	released := Begin(txCtx)
+	if err := Commit(released); err != nil {
+		t.Fatalf("savepoint commit failed: %v", err)
+	}
+	rolledBack := Begin(txCtx)
+	if err := Rollback(rolledBack); err != nil {
+		t.Fatalf("savepoint rollback failed: %v", err)
+	}
+
+	leaked := Begin(txCtx)
+	name := fromContext(leaked).savepoint
+	if sp := OpenSavepoints(txCtx); len(sp) != 1 || sp[0] != name {
+		t.Errorf("expected only the leaked savepoint %s, got %v", name, sp)
+	}
+	if n := log.warningCount(); n != 0 {
+		t.Fatalf("expected no warning before the transaction ends, got %v", log.warnings)
+	}
+
+	if err := Commit(txCtx); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	if len(log.warnings) != 1 || !strings.Contains(log.warnings[0], name) {
+		t.Errorf("expected a warning naming the leaked savepoint, got %v", log.warnings)
+	}
+}
@@ -0,0 +1,26 @@
+package stx
+
+import "context"
+
+// NextSeq returns the next value of the named counter, starting at 1. Counters
+// are scoped to the outermost transaction in ctx, so nested transactions
+// continue the same sequences, and they start over in the next transaction.
+// This is handy for numbering generated rows, such as order lines, without
+// threading a counter through every call. Outside a transaction, and in a
+// foreign transaction resolved through Bridge, NextSeq returns 0.
+func NextSeq(ctx context.Context, name string) int {
+	stx := txState(ctx)
+	if stx == nil {
+		return 0
+	}
+
+	stx = stx.outermost()
+
+	stx.mu.Lock()
+	defer stx.mu.Unlock()
+	if stx.seqs == nil {
+		stx.seqs = make(map[string]int)
+	}
+	stx.seqs[name]++
+	return stx.seqs[name]
+}
@@ -0,0 +1,56 @@
+package stx
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNextSeq(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	if n := NextSeq(ctx, "line"); n != 0 {
+		t.Errorf("expected 0 outside a transaction, got %d", n)
+	}
+
+	for i := 0; i < 2; i++ {
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			var lines, pages []int
+			lines = append(lines, NextSeq(txCtx, "line"), NextSeq(txCtx, "line"))
+			pages = append(pages, NextSeq(txCtx, "page"))
+
+			err := WithTransaction(txCtx, func(innerCtx context.Context) error {
+				lines = append(lines, NextSeq(innerCtx, "line"))
+				pages = append(pages, NextSeq(innerCtx, "page"))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			lines = append(lines, NextSeq(txCtx, "line"))
+
+			if !equalInts(lines, []int{1, 2, 3, 4}) {
+				t.Errorf("unexpected line sequence %v", lines)
+			}
+			if !equalInts(pages, []int{1, 2}) {
+				t.Errorf("unexpected page sequence %v", pages)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package stx
+
+import (
+	"context"
+	"database/sql"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const settingsContextKey contextKey = "stx:settings"
+
+// TxSettings combines the options used to begin a transaction with GORM
+// session settings applied to the transactional DB, so isolation levels and
+// session behavior can be configured in one place.
+type TxSettings struct {
+	// TxOptions are passed to the driver when the transaction begins. Options
+	// passed directly to Begin, WithTransaction or WithDefer take precedence.
+	TxOptions *sql.TxOptions
+	// Session is applied to the transactional DB returned by Current.
+	Session *gorm.Session
+}
+
+// WithSettings returns a context whose transactions, started with Begin,
+// WithTransaction or WithDefer, use the given settings. The settings apply to
+// every transaction started from the returned context, including nested ones.
+func WithSettings(ctx context.Context, settings TxSettings) context.Context {
+	return context.WithValue(ctx, settingsContextKey, settings)
+}
+
+// settingsFromContext returns the settings stored with WithSettings
+func settingsFromContext(ctx context.Context) TxSettings {
+	settings, _ := ctx.Value(settingsContextKey).(TxSettings)
+	return settings
+}
+
+// txOptions returns opts, or the configured TxOptions if opts is empty, or
+// the default set with Configure if neither is given
+func (s TxSettings) txOptions(opts []*sql.TxOptions) []*sql.TxOptions {
+	if len(opts) > 0 {
+		return opts
+	}
+	if s.TxOptions != nil {
+		return []*sql.TxOptions{s.TxOptions}
+	}
+	return defaultTxOptions()
+}
+
+// apply applies the configured session to the transactional DB
+func (s TxSettings) apply(tx *gorm.DB) *gorm.DB {
+	if s.Session == nil || tx.Error != nil {
+		return tx
+	}
+	return tx.Session(s.Session)
+}
+
+// configureTx applies the context's transaction settings, query log,
+// rollback SQL log, trace, abort on warning, statement timeout and deferred
+// constraints to a newly begun transactional DB. It returns the error of the
+// statements issued to configure the transaction, after which the
+// transaction must not be used; a failure to begin is left in tx.Error.
+func configureTx(ctx context.Context, tx *gorm.DB) (*gorm.DB, error) {
+	tx = settingsFromContext(ctx).apply(tx)
+	// Nested transactions inherit the loggers from the enclosing one
+	if log := queryLogFromContext(ctx); log != nil && tx.Error == nil {
+		if !hasLogger(tx.Logger, func(l logger.Interface) bool {
+			ql, ok := l.(*queryLogger)
+			return ok && ql.log == log
+		}) {
+			tx = tx.Session(&gorm.Session{Logger: &queryLogger{wrappedLogger: wrappedLogger{next: tx.Logger}, log: log}})
+		}
+	}
+	if failed := failedStatementFromContext(ctx); failed != nil && tx.Error == nil {
+		if !hasLogger(tx.Logger, func(l logger.Interface) bool {
+			fl, ok := l.(*failureLogger)
+			return ok && fl.failed == failed
+		}) {
+			tx = tx.Session(&gorm.Session{Logger: &failureLogger{wrappedLogger: wrappedLogger{next: tx.Logger}, failed: failed}})
+		}
+	}
+	if t := traceFromContext(ctx); t != nil && tx.Error == nil {
+		if !hasLogger(tx.Logger, func(l logger.Interface) bool {
+			tl, ok := l.(*traceLogger)
+			return ok && tl.trace == t
+		}) {
+			tx = tx.Session(&gorm.Session{Logger: &traceLogger{wrappedLogger: wrappedLogger{next: tx.Logger}, trace: t}})
+		}
+	}
+	if abort, _ := ctx.Value(abortOnWarningContextKey).(bool); abort && tx.Error == nil {
+		if !hasLogger(tx.Logger, func(l logger.Interface) bool {
+			_, ok := l.(*warningLogger)
+			return ok
+		}) {
+			tx = tx.Session(&gorm.Session{Logger: &warningLogger{wrappedLogger: wrappedLogger{next: tx.Logger}, state: &warningState{}}})
+		}
+	}
+	if tx.Error != nil {
+		return tx, nil
+	}
+	if err := applyStatementTimeout(ctx, tx); err != nil {
+		return tx, err
+	}
+	return tx, applyDeferredConstraints(ctx, tx)
+}
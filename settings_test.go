@@ -0,0 +1,176 @@
+package stx
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestWithSettings(t *testing.T) {
+	db, pool := setupPoolTestDB(t)
+	ctx := WithSettings(New(context.Background(), db), TxSettings{
+		TxOptions: &sql.TxOptions{Isolation: sql.LevelSerializable},
+		Session:   &gorm.Session{SkipDefaultTransaction: true},
+	})
+
+	t.Run("Begin", func(t *testing.T) {
+		txCtx := Begin(ctx)
+		defer Rollback(txCtx)
+
+		if opts := pool.lastOpts(); opts == nil || opts.Isolation != sql.LevelSerializable {
+			t.Errorf("expected serializable isolation, got %+v", opts)
+		}
+		if !Current(txCtx).SkipDefaultTransaction {
+			t.Error("expected session setting to apply to transactional DB")
+		}
+		if !IsTx(txCtx) {
+			t.Error("expected IsTx to return true with session applied")
+		}
+	})
+
+	t.Run("WithTransaction", func(t *testing.T) {
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			if !Current(txCtx).SkipDefaultTransaction {
+				t.Error("expected session setting to apply to transactional DB")
+			}
+			model := TestModel{Name: "settings-tx"}
+			return Current(txCtx).Create(&model).Error
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if opts := pool.lastOpts(); opts == nil || opts.Isolation != sql.LevelSerializable {
+			t.Errorf("expected serializable isolation, got %+v", opts)
+		}
+	})
+
+	t.Run("WithDefer", func(t *testing.T) {
+		err := func() (err error) {
+			txCtx, cleanup := WithDefer(ctx)
+			defer cleanup(&err)
+
+			if !Current(txCtx).SkipDefaultTransaction {
+				t.Error("expected session setting to apply to transactional DB")
+			}
+			return nil
+		}()
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+	})
+
+	t.Run("explicit options take precedence", func(t *testing.T) {
+		txCtx := Begin(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+		defer Rollback(txCtx)
+
+		if opts := pool.lastOpts(); opts == nil || opts.Isolation != sql.LevelReadCommitted {
+			t.Errorf("expected read committed isolation, got %+v", opts)
+		}
+	})
+}
+
+func TestIsolationLevel(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	t.Run("not in transaction", func(t *testing.T) {
+		if _, ok := IsolationLevel(ctx); ok {
+			t.Error("expected no isolation level outside a transaction")
+		}
+	})
+
+	t.Run("driver default", func(t *testing.T) {
+		txCtx := Begin(ctx)
+		defer Rollback(txCtx)
+
+		if _, ok := IsolationLevel(txCtx); ok {
+			t.Error("expected no isolation level for default transaction")
+		}
+	})
+
+	t.Run("explicit level with Begin", func(t *testing.T) {
+		txCtx := Begin(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+		defer Rollback(txCtx)
+
+		level, ok := IsolationLevel(txCtx)
+		if !ok || level != sql.LevelSerializable {
+			t.Errorf("expected serializable isolation, got %v, %v", level, ok)
+		}
+
+		// Savepoints share the enclosing transaction's level
+		spCtx := Begin(txCtx)
+		if level, ok := IsolationLevel(spCtx); !ok || level != sql.LevelSerializable {
+			t.Errorf("expected savepoint to report serializable isolation, got %v, %v", level, ok)
+		}
+	})
+
+	t.Run("explicit level with WithTransaction", func(t *testing.T) {
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			if level, ok := IsolationLevel(txCtx); !ok || level != sql.LevelSerializable {
+				t.Errorf("expected serializable isolation, got %v, %v", level, ok)
+			}
+			return WithTransaction(txCtx, func(innerCtx context.Context) error {
+				if level, ok := IsolationLevel(innerCtx); !ok || level != sql.LevelSerializable {
+					t.Errorf("expected nested transaction to report serializable isolation, got %v, %v", level, ok)
+				}
+				return nil
+			})
+		}, &sql.TxOptions{Isolation: sql.LevelSerializable})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+	})
+
+	t.Run("level from settings", func(t *testing.T) {
+		settingsCtx := WithSettings(ctx, TxSettings{TxOptions: &sql.TxOptions{Isolation: sql.LevelSerializable}})
+		txCtx := Begin(settingsCtx)
+		defer Rollback(txCtx)
+
+		if level, ok := IsolationLevel(txCtx); !ok || level != sql.LevelSerializable {
+			t.Errorf("expected serializable isolation, got %v, %v", level, ok)
+		}
+	})
+}
+
+func TestIsReadOnly(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	if IsReadOnly(ctx) {
+		t.Error("expected false outside a transaction")
+	}
+
+	err := WithTransaction(ctx, func(txCtx context.Context) error {
+		if IsReadOnly(txCtx) {
+			t.Error("expected false for a read-write transaction")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+
+	err = Tx(ctx).ReadOnly().Run(func(txCtx context.Context) error {
+		if !IsReadOnly(txCtx) {
+			t.Error("expected true for a read-only transaction")
+		}
+		return WithTransaction(txCtx, func(innerCtx context.Context) error {
+			if !IsReadOnly(innerCtx) {
+				t.Error("expected nested transaction to inherit read-only mode")
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+
+	roCtx := WithSettings(ctx, TxSettings{TxOptions: &sql.TxOptions{ReadOnly: true}})
+	txCtx := Begin(roCtx)
+	defer Rollback(txCtx)
+	if !IsReadOnly(txCtx) {
+		t.Error("expected true for a transaction begun with read-only settings")
+	}
+}
@@ -0,0 +1,40 @@
+package stx
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// NewSharded returns a context for horizontally sharded data. Instead of a
+// single database it carries shards by name, and router picks the shard for a
+// context, typically from a context value such as the tenant:
+//
+//	ctx = stx.NewSharded(ctx, shards, func(ctx context.Context) string {
+//	    return tenantShard(ctx.Value(tenantKey{}))
+//	})
+//
+// Current and Detached resolve the shard on every call, and Begin,
+// WithTransaction and WithDefer resolve it once when the transaction starts:
+// the transaction and everything nested in it stays on that shard. If router
+// names a shard that does not exist, Current returns nil and transactions
+// fail with gorm.ErrInvalidTransaction.
+func NewSharded(ctx context.Context, shards map[string]*gorm.DB, router func(ctx context.Context) string) context.Context {
+	copied := make(map[string]*gorm.DB, len(shards))
+	for name, db := range shards {
+		copied[name] = db
+	}
+	resolver := func(ctx context.Context) *gorm.DB {
+		return copied[router(ctx)]
+	}
+	return context.WithValue(ctx, txContextKey, &STX{resolver: resolver})
+}
+
+// resolveDB returns the database of stx, resolving it from ctx for contexts
+// created with NewSharded or Bridge
+func (stx *STX) resolveDB(ctx context.Context) *gorm.DB {
+	if stx.resolver == nil {
+		return stx.db
+	}
+	return stx.resolver(ctx)
+}
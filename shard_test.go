@@ -0,0 +1,79 @@
+package stx
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type shardKey struct{}
+
+func TestNewSharded(t *testing.T) {
+	shards := map[string]*gorm.DB{
+		"eu": setupTestDB(t),
+		"us": setupTestDB(t),
+	}
+	router := func(ctx context.Context) string {
+		shard, _ := ctx.Value(shardKey{}).(string)
+		return shard
+	}
+	ctx := NewSharded(context.Background(), shards, router)
+
+	count := func(db *gorm.DB, name string) int64 {
+		var n int64
+		db.Model(&TestModel{}).Where("name = ?", name).Count(&n)
+		return n
+	}
+
+	t.Run("writes land on the selected shard", func(t *testing.T) {
+		euCtx := context.WithValue(ctx, shardKey{}, "eu")
+		if Current(euCtx) != shards["eu"] {
+			t.Error("expected Current to route to the eu shard")
+		}
+
+		err := WithTransaction(euCtx, func(txCtx context.Context) error {
+			// Changing the routing value mid-transaction does not move it
+			moved := context.WithValue(txCtx, shardKey{}, "us")
+			if err := Current(moved).Create(&TestModel{Name: "eu-user"}).Error; err != nil {
+				return err
+			}
+			return WithTransaction(moved, func(nestedCtx context.Context) error {
+				return Current(nestedCtx).Create(&TestModel{Name: "eu-nested"}).Error
+			})
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		if count(shards["eu"], "eu-user") != 1 || count(shards["eu"], "eu-nested") != 1 {
+			t.Error("expected rows on the eu shard")
+		}
+		if count(shards["us"], "eu-user") != 0 || count(shards["us"], "eu-nested") != 0 {
+			t.Error("expected no rows on the us shard")
+		}
+
+		usCtx := context.WithValue(ctx, shardKey{}, "us")
+		txCtx := Begin(usCtx)
+		if err := Current(txCtx).Create(&TestModel{Name: "us-user"}).Error; err != nil {
+			t.Fatalf("create failed: %v", err)
+		}
+		if err := Commit(txCtx); err != nil {
+			t.Fatalf("commit failed: %v", err)
+		}
+		if count(shards["us"], "us-user") != 1 || count(shards["eu"], "us-user") != 0 {
+			t.Error("expected the row on the us shard only")
+		}
+	})
+
+	t.Run("unknown shard", func(t *testing.T) {
+		unknown := context.WithValue(ctx, shardKey{}, "apac")
+		if Current(unknown) != nil {
+			t.Error("expected no database for an unknown shard")
+		}
+		err := WithTransaction(unknown, func(context.Context) error { return nil })
+		if err != gorm.ErrInvalidTransaction {
+			t.Errorf("expected ErrInvalidTransaction, got %v", err)
+		}
+	})
+}
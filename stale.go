@@ -0,0 +1,54 @@
+package stx
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// detectStaleContext is 1 when stale context detection is enabled
+var detectStaleContext uint32
+
+// SetDetectStaleContext enables or disables a debugging aid for a common
+// mistake: calling Current with the context a transaction was started from,
+// rather than the transaction's own context, while the transaction is still
+// running. Such calls silently bypass the transaction. When enabled, Current
+// logs a warning through the DB's GORM logger whenever it is called on the
+// context of a transaction in which a nested transaction or savepoint is
+// still active.
+//
+// Only transaction contexts are checked: the context returned by New may be
+// shared by unrelated requests, so using it while a transaction started from
+// it runs is not reported.
+//
+// This is a heuristic: stx cannot tell goroutines apart, so a context that is
+// legitimately shared with other goroutines while one of them runs a
+// transaction is reported as well. It is disabled by default and intended for
+// development and tests.
+func SetDetectStaleContext(enabled bool) {
+	var v uint32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreUint32(&detectStaleContext, v)
+}
+
+// warnIfStale logs a warning if detection is enabled and a transaction
+// nested in stx is still active
+func (stx *STX) warnIfStale(ctx context.Context) {
+	if atomic.LoadUint32(&detectStaleContext) == 0 || atomic.LoadInt32(&stx.children) == 0 {
+		return
+	}
+	if stx.db == nil || stx.db.Logger == nil {
+		return
+	}
+
+	// A nested transaction leaked with Begin keeps counting after stx ended
+	stx.mu.RLock()
+	ended := stx.ended
+	stx.mu.RUnlock()
+	if ended {
+		return
+	}
+
+	stx.db.Logger.Warn(ctx, "stx: Current called on a context whose transaction has been superseded by an active nested transaction; use the nested transaction's context instead")
+}
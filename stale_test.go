@@ -0,0 +1,118 @@
+package stx
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestDetectStaleContext(t *testing.T) {
+	log := &capturingLogger{}
+	db := setupTestDB(t).Session(&gorm.Session{Logger: log})
+	ctx := New(context.Background(), db)
+
+	SetDetectStaleContext(true)
+	defer SetDetectStaleContext(false)
+
+	t.Run("base context shared with a transaction", func(t *testing.T) {
+		before := log.warningCount()
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			// ctx may be shared with unrelated work, so it is not reported
+			Current(ctx)
+			Current(txCtx)
+			if log.warningCount() != before {
+				t.Error("expected no warning for the base context")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+	})
+
+	t.Run("outer context used after transaction ends", func(t *testing.T) {
+		txCtx := Begin(ctx)
+		if err := Commit(txCtx); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+
+		before := log.warningCount()
+		Current(ctx)
+		if log.warningCount() != before {
+			t.Error("expected no warning once the transaction has finished")
+		}
+	})
+
+	t.Run("nested transaction context", func(t *testing.T) {
+		err := func() (err error) {
+			outerCtx, cleanup := WithDefer(ctx)
+			defer cleanup(&err)
+
+			innerCtx, innerCleanup := WithDefer(outerCtx)
+			before := log.warningCount()
+			Current(outerCtx)
+			if log.warningCount() != before+1 {
+				t.Error("expected a warning for the outer transaction context while a savepoint is active")
+			}
+
+			var innerErr error
+			innerCleanup(&innerErr)
+			Current(innerCtx)
+			Current(outerCtx)
+			if log.warningCount() != before+1 {
+				t.Error("expected no further warnings after the savepoint finished")
+			}
+			return innerErr
+		}()
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+	})
+
+	t.Run("leaked savepoint", func(t *testing.T) {
+		outerCtx := Begin(ctx)
+		Begin(outerCtx)
+		if err := Commit(outerCtx); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+
+		before := log.warningCount()
+		Current(outerCtx)
+		if log.warningCount() != before {
+			t.Error("expected no warning once the enclosing transaction has ended")
+		}
+	})
+
+	t.Run("aborted savepoint", func(t *testing.T) {
+		outerCtx := Begin(ctx)
+		defer Rollback(outerCtx)
+
+		spCtx := Begin(outerCtx)
+		if err := Rollback(spCtx); err != nil {
+			t.Fatalf("failed to roll back savepoint: %v", err)
+		}
+
+		before := log.warningCount()
+		Current(outerCtx)
+		if log.warningCount() != before {
+			t.Error("expected no warning after the savepoint was rolled back")
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		SetDetectStaleContext(false)
+		defer SetDetectStaleContext(true)
+
+		before := log.warningCount()
+		WithTransaction(ctx, func(txCtx context.Context) error {
+			spCtx := Begin(txCtx)
+			defer Rollback(spCtx)
+			Current(txCtx)
+			return nil
+		})
+		if log.warningCount() != before {
+			t.Error("expected no warning when detection is disabled")
+		}
+	})
+}
@@ -4,21 +4,124 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"gorm.io/gorm"
 )
 
 type contextKey string
 
-const txContextKey contextKey = "stx:tx"
+const (
+	txContextKey         contextKey = "stx:tx"
+	requestIDContextKey  contextKey = "stx:request_id"
+	rollbackIfContextKey contextKey = "stx:rollback_if"
+	sharedCallbacksKey   contextKey = "stx:shared_callbacks"
+	valuesContextKey     contextKey = "stx:values"
+	flatNestingKey       contextKey = "stx:flat_nesting"
+	withoutCallbacksKey  contextKey = "stx:without_callbacks"
+)
 
 type STX struct {
-	mu        sync.RWMutex
-	db        *gorm.DB
+	// mu guards the mutable callback state. db is set at construction and
+	// never changed afterwards; transactions create new STX values instead.
+	mu sync.RWMutex
+	db *gorm.DB
+	// resolver replaces db for contexts created with NewSharded or Bridge.
+	// Like db, it is never changed after construction.
+	resolver  func(context.Context) *gorm.DB
 	callbacks []func()
+	prepares  []func() error
+	// owned reports whether stx began the transaction and is therefore
+	// responsible for committing or rolling it back.
+	owned bool
+	// savepoint is set when the STX represents a savepoint nested in an
+	// enclosing transaction rather than a transaction of its own.
+	savepoint string
+	// timer aborts the transaction once it exceeds the maximum lifetime.
+	// finished records that the transaction ended and aborted why it was
+	// aborted from another goroutine; all three are guarded by mu.
+	timer    *time.Timer
+	finished bool
+	aborted  error
+	// txOptions are the options the transaction was begun with, if any.
+	// Savepoints and nested transactions share those of the enclosing one.
+	txOptions *sql.TxOptions
+	// beginErr is the error beginning the transaction or savepoint failed
+	// with, in which case the STX does not represent a usable transaction.
+	beginErr error
+	// parent is the STX of the context the transaction was started from.
+	// shareCallbacks reports whether callbacks are handed to it on success,
+	// see WithSharedCallbacks.
+	parent         *STX
+	shareCallbacks bool
+	// children counts the active transactions nested in this transaction
+	// and released records whether this STX no longer counts towards its
+	// parent's children. Both are accessed atomically.
+	children int32
+	released uint32
+	// id identifies the transaction, see TxID. Nested transactions share
+	// the id of the enclosing one.
+	id string
+	// depth is the nesting level of the transaction: 1 for a top-level
+	// transaction, incremented for each enclosing transaction or savepoint.
+	depth int
+	// seqs holds the counters handed out by NextSeq
+	seqs map[string]int
+	// writes holds the results of WriteOnce, by key
+	writes map[string]*writeOnce
+	// batches holds the items accumulated with OnSuccessBatch, by key
+	batches map[string][]any
+	// watchers are the channels returned by Watch, notified once the
+	// transaction has ended
+	watchers []chan TxEvent
+	ended    bool
+	// tracked marks transactions counted by OpenTransactions
+	tracked bool
+	// tags holds the tags attached with Tag
+	tags []string
+	// trace records the transaction's lifecycle in a WithTrace scope
+	trace *trace
+	// queryErrs and lastErr hold the errors collected for QueryErrors and
+	// LastError. Only the STX of the top-level transaction collects them.
+	queryErrs []error
+	lastErr   error
+	// callbacksDone records that the callbacks have run, been handed to the
+	// parent or been discarded by a rollback, see CheckPending
+	callbacksDone bool
+	// callbacksFired records that the callbacks have run, see CallbacksFired
+	callbacksFired bool
+	// savepoints holds the names of the open savepoints created with Begin.
+	// Only the STX of the top-level transaction tracks them.
+	savepoints []string
+	// rollbackOnly makes the WithDefer cleanup roll back, see WithDeferAbort
+	rollbackOnly bool
+	// connDiscard releases the dedicated connection of a transaction begun
+	// under WithDedicatedConn
+	connDiscard func()
+}
+
+// savepointSeq makes savepoint names unique
+var savepointSeq uint64
+
+// nextSavepointName returns a unique savepoint name
+func nextSavepointName() string {
+	return fmt.Sprintf("stx_sp%d", atomic.AddUint64(&savepointSeq, 1))
 }
 
+// ErrNilFunc is returned when a nil function is passed to WithTransaction,
+// Detached, Try or Suspend
+var ErrNilFunc = errors.New("stx: nil transaction function")
+
+// ErrNoDatabase is reported by the WithDefer cleanup in strict mode when the
+// context holds no database, see SetStrictDefer.
+var ErrNoDatabase = errors.New("stx: no database in context")
+
+// strictDefer is non-zero when SetStrictDefer is enabled
+var strictDefer int32
+
 // STXError represents an error with additional context
 type STXError struct {
 	Message string
@@ -41,10 +144,11 @@ func newSTXError(message string, err error) *STXError {
 	return &STXError{Message: message, Err: err}
 }
 
-// panicError creates an error for panic recovery
+// panicError creates an error for panic recovery. Panics carrying an error
+// are returned as-is so callers can match the original type with errors.As.
 func panicError(v any) error {
 	if err, ok := v.(error); ok {
-		return newSTXError("recovered from panic", err)
+		return err
 	}
 	if str, ok := v.(string); ok {
 		return newSTXError("recovered from panic", errors.New(str))
@@ -52,28 +156,202 @@ func panicError(v any) error {
 	return errors.New("recovered from panic")
 }
 
-func New(ctx context.Context, db *gorm.DB) context.Context {
-	return context.WithValue(ctx, txContextKey, &STX{db: db})
+// runRecovered calls fn, converting a panic into an error with panicError
+func runRecovered(ctx context.Context, fn func(context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(r)
+		}
+	}()
+	return fn(ctx)
 }
 
-func Current(ctx context.Context) *gorm.DB {
+// fromContext returns the STX stored in the context, or nil if there is none
+func fromContext(ctx context.Context) *STX {
 	if ctx == nil {
 		return nil
 	}
 
-	val := ctx.Value(txContextKey)
-	if val == nil {
+	stx, ok := ctx.Value(txContextKey).(*STX)
+	if !ok {
 		return nil
 	}
+	return stx
+}
 
-	stx, ok := val.(*STX)
-	if !ok || stx == nil {
+// New returns a context carrying db, from which Current and transactions
+// work. New is idempotent: if ctx already carries db, for example because
+// New was called twice or with Current(ctx) within a transaction, ctx is
+// returned unchanged, so it stays transactional and keeps its pending
+// callbacks. Passing a different DB replaces it for the returned context;
+// transactions already in progress in ctx are unaffected and their callbacks
+// still run when they commit.
+func New(ctx context.Context, db *gorm.DB) context.Context {
+	if stx := fromContext(ctx); stx != nil && stx.resolver == nil && stx.db == db {
+		return ctx
+	}
+	ctx, _ = withDB(ctx, db)
+	return ctx
+}
+
+// withDB returns a context carrying a new STX for db, and that STX
+func withDB(ctx context.Context, db *gorm.DB) (context.Context, *STX) {
+	stx := &STX{db: db}
+	return context.WithValue(ctx, txContextKey, stx), stx
+}
+
+// Current returns the DB of the transaction in ctx, or the DB passed to New
+// outside a transaction. It returns nil if ctx carries no DB. The returned DB
+// keeps the context it was bound to with WithContext before being passed to
+// New, however many values stx or the caller add to ctx afterwards, so
+// cancelling that context still aborts its queries.
+func Current(ctx context.Context) *gorm.DB {
+	stx := fromContext(ctx)
+	if stx == nil {
 		return nil
 	}
 
-	stx.mu.RLock()
-	defer stx.mu.RUnlock()
-	return stx.db
+	stx.warnIfStale(ctx)
+
+	// db and resolver are immutable, so no lock is needed
+	return stx.resolveDB(ctx)
+}
+
+// rootDB returns a handle on the connection pool underlying db, outside of
+// any transaction db may be part of
+func rootDB(db *gorm.DB) *gorm.DB {
+	root := db.Session(&gorm.Session{NewDB: true, Context: db.Statement.Context})
+	root.Statement.ConnPool = root.ConnPool
+	return root
+}
+
+// Detached runs fn with a context that carries the same database but no
+// transaction: within fn, Current returns the root DB, IsTx is false and
+// OnSuccess callbacks run immediately instead of attaching to the enclosing
+// transaction. Writes made in fn commit on their own, independently of the
+// enclosing transaction's outcome, which suits audit or metrics rows that
+// must persist even if the transaction rolls back.
+func Detached(ctx context.Context, fn func(ctx context.Context) error) error {
+	if fn == nil {
+		return ErrNilFunc
+	}
+
+	db := Current(ctx)
+	if db == nil {
+		return gorm.ErrInvalidTransaction
+	}
+
+	detachedCtx, _ := withDB(ctx, rootDB(db))
+	return fn(detachedCtx)
+}
+
+// Suspend is an alias of Detached, for calling legacy code that expects
+// auto-commit semantics from within a transaction: the transaction in ctx is
+// untouched and still in effect for ctx once fn returns.
+func Suspend(ctx context.Context, fn func(ctx context.Context) error) error {
+	return Detached(ctx, fn)
+}
+
+// Try runs fn on a savepoint of the current transaction. If fn returns an
+// error, only the savepoint is rolled back and the error is returned; the
+// enclosing transaction stays usable, so optional work can be attempted
+// without aborting everything. OnSuccess callbacks registered within fn run
+// when fn succeeds, as with a nested WithTransaction. Outside a transaction fn
+// simply runs with ctx.
+func Try(ctx context.Context, fn func(ctx context.Context) error) error {
+	if fn == nil {
+		return ErrNilFunc
+	}
+	if !IsTx(ctx) {
+		return fn(ctx)
+	}
+	return withTransaction(ctx, fn, false)
+}
+
+// Adopt wraps a transaction that was created outside of stx into a context.
+// Current, IsTx and OnSuccess work as usual, but the transaction is not owned
+// by stx: Commit, Rollback and WithDefer leave it to the caller. Since stx
+// never observes the commit, the caller reports the outcome by calling done
+// once the transaction has ended: done(true) after a commit runs the OnSuccess
+// callbacks registered on the returned context and notifies Watch, done(false)
+// after a rollback discards them. Only the first call to done counts. Nested
+// WithTransaction calls still use savepoints and run their own callbacks when
+// they complete.
+//
+//	tx := db.Begin()
+//	txCtx, done := stx.Adopt(ctx, tx)
+//	if err := createUser(txCtx); err != nil {
+//	    tx.Rollback()
+//	    done(false)
+//	    return err
+//	}
+//	err := tx.Commit().Error
+//	done(err == nil)
+func Adopt(ctx context.Context, tx *gorm.DB) (txCtx context.Context, done func(committed bool)) {
+	txCtx, stx := withDB(ctx, tx)
+
+	var once sync.Once
+	done = func(committed bool) {
+		once.Do(func() {
+			if !committed {
+				stx.mu.Lock()
+				stx.callbacks = nil
+				stx.mu.Unlock()
+				stx.end(TxRolledBack)
+				return
+			}
+			stx.end(TxCommitted)
+			stx.runCallbacks()
+		})
+	}
+	return txCtx, done
+}
+
+// WithRequestID returns a context carrying the given request or trace ID.
+// The ID is inherited by every transaction context derived from it, so side
+// effects triggered within a transaction can be correlated with the request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, id)
+}
+
+// RequestID returns the request ID stored with WithRequestID, or an empty
+// string if none is set.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// WithValues returns a context carrying the given metadata, such as tenant or
+// actor, for transactions started from it. Values are merged with those
+// already stored in ctx, with the new values taking precedence, and are
+// readable with Value from the transaction's context and from callbacks
+// capturing it. The map is copied, so later changes to it have no effect.
+func WithValues(ctx context.Context, values map[string]any) context.Context {
+	merged := make(map[string]any)
+	if existing, ok := ctx.Value(valuesContextKey).(map[string]any); ok {
+		for k, v := range existing {
+			merged[k] = v
+		}
+	}
+	for k, v := range values {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, valuesContextKey, merged)
+}
+
+// Value returns the metadata value stored under key with WithValues, or nil
+// if there is none
+func Value(ctx context.Context, key string) any {
+	if ctx == nil {
+		return nil
+	}
+
+	values, _ := ctx.Value(valuesContextKey).(map[string]any)
+	return values[key]
 }
 
 // GetCurrent is deprecated, use Current instead
@@ -81,42 +359,187 @@ func GetCurrent(ctx context.Context) *gorm.DB {
 	return Current(ctx)
 }
 
+// RollbackIf returns a context that makes WithTransaction calls on it swallow
+// errors matching pred. A matched error still rolls the transaction back, but
+// WithTransaction returns nil instead of the error; unmatched errors roll back
+// and propagate as usual. Since the transaction is rolled back, OnSuccess
+// callbacks registered within it do not fire. The context can be reused for
+// any number of transactions, but those nested in them do not inherit the
+// predicate.
+func RollbackIf(ctx context.Context, pred func(error) bool) context.Context {
+	return context.WithValue(ctx, rollbackIfContextKey, pred)
+}
+
+// WithTransaction runs fn in a transaction, which is committed if fn returns
+// nil and rolled back otherwise. OnSuccess callbacks registered within fn run
+// after the commit. When ctx is already in a transaction, fn runs on a
+// savepoint. A panic in fn rolls the transaction back and is returned as an
+// error, the same way WithDefer handles panics.
 func WithTransaction(ctx context.Context, fn func(context.Context) error, opts ...*sql.TxOptions) error {
+	return withTransaction(ctx, fn, true, opts...)
+}
+
+// withTransaction implements WithTransaction. Helpers whose behavior rests on
+// a savepoint, such as Try, pass false for flatten, so that WithFlatNesting
+// cannot turn their rollback into a no-op. fn still runs with the flat
+// nesting flag, so plain nested calls within it join its savepoint.
+func withTransaction(ctx context.Context, fn func(context.Context) error, flatten bool, opts ...*sql.TxOptions) error {
+	if fn == nil {
+		return ErrNilFunc
+	}
+
 	db := Current(ctx)
 	if db == nil {
 		return gorm.ErrInvalidTransaction
 	}
 
-	return db.Transaction(func(tx *gorm.DB) error {
-		newCtx := context.WithValue(ctx, txContextKey, &STX{db: tx})
-		err := fn(newCtx)
-		
-		// Execute success callbacks if no error occurred
-		if err == nil {
-			if val := newCtx.Value(txContextKey); val != nil {
-				if stx, ok := val.(*STX); ok && stx != nil {
-					stx.mu.RLock()
-					callbacks := make([]func(), len(stx.callbacks))
-					copy(callbacks, stx.callbacks)
-					stx.mu.RUnlock()
-					
-					for _, callback := range callbacks {
-						if callback != nil {
-							callback()
-						}
-					}
+	// Shadow the predicate so transactions nested in this one don't see it
+	rollbackIf, _ := ctx.Value(rollbackIfContextKey).(func(error) bool)
+	if rollbackIf != nil {
+		ctx = context.WithValue(ctx, rollbackIfContextKey, nil)
+	}
+
+	if flat, _ := ctx.Value(flatNestingKey).(bool); flat && flatten && IsTx(ctx) {
+		return fn(ctx)
+	}
+
+	if !IsTx(ctx) {
+		var cancel context.CancelFunc
+		ctx, cancel = withDefaultTimeout(ctx)
+		defer cancel()
+	}
+
+	settings := settingsFromContext(ctx)
+	txOpts := settings.txOptions(opts)
+
+	// Nested transactions run on a savepoint of the enclosing transaction
+	txOptions := firstTxOptions(txOpts)
+	if parent := fromContext(ctx); parent != nil && IsTx(ctx) {
+		txOptions = parent.txOptions
+	}
+
+	var fnErr error
+	var stx *STX
+	var chaos chaosOutcome
+	err := withConn(ctx, preparedDB(ctx, db), func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			// fn must not run on a transaction that failed to configure
+			tx, err := configureTx(ctx, tx)
+			if err != nil {
+				return err
+			}
+			stx = newChild(ctx, &STX{db: tx, owned: true, txOptions: txOptions})
+			defer stx.release()
+			// The transaction issues no further statements once fn and the
+			// prepare hooks are done, even if fn panics
+			defer stx.removeScopedCallbacks()
+			defer stx.untrackQueryErrors()
+			newCtx := context.WithValue(ctx, txContextKey, stx)
+			runBeginHooks(newCtx)
+			err = runRecovered(newCtx, fn)
+			fnErr = err
+
+			// Run prepare hooks before GORM commits the transaction
+			if err == nil {
+				err = stx.runPrepares()
+			}
+			if err == nil {
+				err = warningLogged(stx.db)
+			}
+			if err == nil {
+				if chaos = chaosFor(ctx); chaos == chaosRollback {
+					err = ErrChaosRollback
 				}
 			}
+			return err
+		}, txOpts...)
+	})
+
+	if err != nil {
+		if stx != nil {
+			stx.end(TxRolledBack)
 		}
-		
+		logFailedStatement(ctx, db, stx)
+		if err == fnErr && rollbackIf != nil && rollbackIf(err) {
+			return nil
+		}
+		return err
+	}
+
+	// db.Transaction only returns nil once the commit (or savepoint release)
+	// has succeeded, so success callbacks never run for a failed commit
+	stx.end(TxCommitted)
+	if chaos == chaosCommitError {
+		return ErrChaosCommitFailed
+	}
+	stx.runCallbacks()
+	return nil
+}
+
+// WithTransactionIfMany runs fn in a transaction only if it performs at least
+// threshold writes, sparing mostly-single-write handlers the overhead of a
+// transaction. fn declares its number of writes by calling writes before it
+// starts writing, and must use the context writes returns: a new transaction
+// if n reaches threshold, ctx otherwise. The transaction is committed when fn
+// returns nil and rolled back otherwise, like WithTransaction, and a panic in
+// fn is returned as an error the same way. Only the first call to writes
+// counts.
+//
+//	err := stx.WithTransactionIfMany(ctx, 2, func(ctx context.Context, writes func(int) context.Context) error {
+//	    ctx = writes(len(items))
+//	    for _, item := range items {
+//	        if err := stx.Current(ctx).Create(&item).Error; err != nil {
+//	            return err
+//	        }
+//	    }
+//	    return nil
+//	})
+func WithTransactionIfMany(ctx context.Context, threshold int, fn func(ctx context.Context, writes func(n int) context.Context) error) (err error) {
+	if fn == nil {
+		return ErrNilFunc
+	}
+
+	var txCtx context.Context
+	var started bool
+	writes := func(n int) context.Context {
+		if txCtx == nil {
+			txCtx = ctx
+			if n >= threshold {
+				txCtx = Begin(ctx)
+				started = true
+			}
+		}
+		return txCtx
+	}
+
+	// A panic in fn rolls back and is returned as an error, as in
+	// WithTransaction
+	err = runRecovered(ctx, func(ctx context.Context) error {
+		return fn(ctx, writes)
+	})
+	if !started {
+		return err
+	}
+	if err != nil {
+		Rollback(txCtx)
+		return err
+	}
+	if beginErr := BeginError(txCtx); beginErr != nil {
+		return newSTXError("failed to begin transaction", beginErr)
+	}
+	if err := Commit(txCtx); err != nil {
 		return err
-	}, opts...)
+	}
+
+	fromContext(txCtx).runCallbacks()
+	return nil
 }
 
 // OnSuccess registers a callback to execute when the transaction successfully commits.
 // If the context does not contain a transaction, the callback executes immediately.
 // This is useful for triggering events, notifications, or other side effects after
-// successful database operations.
+// successful database operations. Callbacks registered directly on a foreign
+// transaction resolved through Bridge are dropped, see Bridge.
 //
 // Example usage:
 //   stx.OnSuccess(ctx, func() {
@@ -128,6 +551,15 @@ func WithTransaction(ctx context.Context, fn func(context.Context) error, opts .
 //       eventStream.Emit("user_created", userID)
 //   })
 func OnSuccess(ctx context.Context, callback func()) {
+	if ctx == nil || callbacksSuppressed(ctx) {
+		return
+	}
+	addCallback(ctx, callback)
+}
+
+// addCallback is OnSuccess regardless of WithoutCallbacks, for helpers such
+// as AddCompensation whose callbacks carry state rather than side effects
+func addCallback(ctx context.Context, callback func()) {
 	if ctx == nil || callback == nil {
 		return
 	}
@@ -140,11 +572,16 @@ func OnSuccess(ctx context.Context, callback func()) {
 	}
 
 	stx, ok := val.(*STX)
-	if !ok || stx == nil {
-		// Invalid transaction context, execute immediately
+	if !ok || stx == nil || !IsTx(ctx) {
+		// Invalid or non-transactional context, execute immediately
 		callback()
 		return
 	}
+	if stx.resolver != nil {
+		// A foreign transaction resolved through Bridge: stx never sees it
+		// commit, and its STX is shared with every other one
+		return
+	}
 
 	// Add callback to be executed on successful commit
 	stx.mu.Lock()
@@ -152,14 +589,367 @@ func OnSuccess(ctx context.Context, callback func()) {
 	stx.mu.Unlock()
 }
 
+// CallbacksFired reports whether the OnSuccess callbacks of the transaction
+// in ctx have run, which happens once, after a successful commit. Code that
+// may be reached both before and after that point can use it to avoid
+// emitting an event twice. It reports true as soon as the callbacks start
+// running, so a callback sees true as well. It is false outside a
+// transaction, after a rollback, and for nested transactions whose callbacks
+// were handed to the enclosing transaction with WithSharedCallbacks.
+func CallbacksFired(ctx context.Context) bool {
+	stx := fromContext(ctx)
+	if stx == nil {
+		return false
+	}
+
+	stx.mu.RLock()
+	defer stx.mu.RUnlock()
+	return stx.callbacksFired
+}
+
+// WithoutCallbacks returns a context in which OnSuccess, EmitOnSuccess and
+// OnSuccessBatch drop their callbacks, inside or outside a transaction. It
+// lets a transaction run a helper whose side effects are unwanted in that
+// case, while keeping its own callbacks:
+//
+//	err := stx.WithTransaction(ctx, func(txCtx context.Context) error {
+//	    stx.OnSuccess(txCtx, notifyUser)                   // fires on commit
+//	    return importContacts(stx.WithoutCallbacks(txCtx)) // callbacks dropped
+//	})
+//
+// Callbacks registered before, or through contexts not derived from the
+// returned one, are unaffected. Only the three functions above, and
+// Repo.OnSuccess, are affected: compensations registered with
+// AddCompensation and work scheduled with OnSuccessTx are kept.
+func WithoutCallbacks(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutCallbacksKey, true)
+}
+
+// callbacksSuppressed reports whether ctx was derived from WithoutCallbacks
+func callbacksSuppressed(ctx context.Context) bool {
+	suppressed, _ := ctx.Value(withoutCallbacksKey).(bool)
+	return suppressed
+}
+
+// SnapshotCallbacks records the OnSuccess callbacks pending on the transaction
+// in ctx and returns a function that discards every callback registered since,
+// for speculative branches whose side effects may need to be dropped:
+//
+//	restore := stx.SnapshotCallbacks(txCtx)
+//	if err := tryBranch(txCtx); err != nil {
+//	    restore()
+//	}
+//
+// Callbacks registered before the snapshot are kept, and so are the items
+// added with OnSuccessBatch. Outside a transaction, where callbacks run
+// immediately, the returned function does nothing.
+func SnapshotCallbacks(ctx context.Context) func() {
+	stx := txState(ctx)
+	if stx == nil {
+		return func() {}
+	}
+
+	// The batches are restored along with the callbacks, since the first
+	// item of a key registers the callback flushing them
+	stx.mu.RLock()
+	n := len(stx.callbacks)
+	batches := make(map[string]int, len(stx.batches))
+	for key, items := range stx.batches {
+		batches[key] = len(items)
+	}
+	stx.mu.RUnlock()
+
+	return func() {
+		stx.mu.Lock()
+		if len(stx.callbacks) > n {
+			stx.callbacks = stx.callbacks[:n]
+		}
+		for key, items := range stx.batches {
+			if m, ok := batches[key]; !ok {
+				delete(stx.batches, key)
+			} else if len(items) > m {
+				stx.batches[key] = items[:m]
+			}
+		}
+		stx.mu.Unlock()
+	}
+}
+
+// Prepare registers a hook to run right before the transaction commits, after
+// the transaction's work has succeeded. Hooks run in registration order; if
+// one returns an error the remaining hooks are skipped, the transaction is
+// rolled back and the error is returned from WithTransaction, Commit or the
+// WithDefer cleanup. This gives external resources a prepare phase that can
+// still veto the commit, unlike OnSuccess which runs after it.
+//
+// If the context does not contain a transaction, or a foreign transaction
+// resolved through Bridge whose commit stx does not observe, fn runs
+// immediately and its error is returned.
+func Prepare(ctx context.Context, fn func() error) error {
+	if ctx == nil || fn == nil {
+		return nil
+	}
+
+	stx := txState(ctx)
+	if stx == nil {
+		return fn()
+	}
+
+	stx.mu.Lock()
+	stx.prepares = append(stx.prepares, fn)
+	stx.mu.Unlock()
+	return nil
+}
+
+// runPrepares runs the registered prepare hooks, stopping at the first error
+func (stx *STX) runPrepares() error {
+	stx.mu.RLock()
+	prepares := make([]func() error, len(stx.prepares))
+	copy(prepares, stx.prepares)
+	stx.mu.RUnlock()
+
+	for _, prepare := range prepares {
+		if err := prepare(); err != nil {
+			return newSTXError("prepare hook failed", err)
+		}
+	}
+	return nil
+}
+
+// end tears the transaction down once it has committed or rolled back: it
+// removes the GORM callbacks scoped to the transaction, stops collecting its
+// query errors and counting it as open, tracks the open savepoints, forgets
+// the WriteOnce keys of a rolled back savepoint, records the outcome in the
+// trace and finally reports it to the watchers. Only the first call has an
+// effect.
+func (stx *STX) end(event TxEvent) {
+	stx.mu.Lock()
+	if stx.ended {
+		stx.mu.Unlock()
+		return
+	}
+	stx.ended = true
+	if event == TxRolledBack {
+		// The callbacks of a rolled back transaction are discarded
+		stx.callbacksDone = true
+	}
+	watchers := stx.watchers
+	stx.watchers = nil
+	stx.mu.Unlock()
+
+	stx.release()
+	stx.removeScopedCallbacks()
+	stx.untrackQueryErrors()
+	if stx.savepoint != "" {
+		stx.untrackSavepoint()
+	} else if stx.depth == 1 {
+		stx.warnOpenSavepoints()
+	}
+	if event == TxRolledBack {
+		stx.forgetWrites()
+	}
+	if event == TxCommitted {
+		stx.trace.record(TraceCommit, "depth %d", stx.depth)
+	} else {
+		stx.trace.record(TraceRollback, "depth %d", stx.depth)
+	}
+	if stx.tracked {
+		atomic.AddInt64(&openTransactions, -1)
+	}
+
+	notifyWatchers(watchers, event)
+}
+
+// runCallbacks executes the registered success callbacks in registration
+// order. If callbacks are shared with an enclosing transaction, they are
+// handed to it instead, to run when it commits.
+func (stx *STX) runCallbacks() {
+	stx.mu.Lock()
+	callbacks := make([]func(), len(stx.callbacks))
+	copy(callbacks, stx.callbacks)
+	stx.callbacksDone = true
+	stx.mu.Unlock()
+
+	if stx.shareCallbacks && stx.parent != nil {
+		stx.parent.mu.Lock()
+		stx.parent.callbacks = append(stx.parent.callbacks, callbacks...)
+		stx.parent.mu.Unlock()
+		return
+	}
+
+	stx.mu.Lock()
+	stx.callbacksFired = true
+	stx.mu.Unlock()
+
+	for i, callback := range callbacks {
+		if callback != nil {
+			stx.runWatched(callback)
+			stx.trace.record(TraceCallback, "%d of %d", i+1, len(callbacks))
+		}
+	}
+}
+
+// WithSharedCallbacks returns a context whose nested transactions, started
+// with WithTransaction, WithDefer or Begin, share their OnSuccess callbacks
+// with the enclosing transaction: when the nested transaction completes, its
+// callbacks are handed to the enclosing one and only fire once the outermost
+// transaction commits. If the nested transaction rolls back, its callbacks are
+// discarded. Outside a transaction the context behaves as usual.
+func WithSharedCallbacks(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sharedCallbacksKey, true)
+}
+
+// WithFlatNesting returns a context in which nested WithTransaction calls join
+// the enclosing transaction instead of starting a savepoint: fn simply runs
+// with the transaction's context, its OnSuccess callbacks attach to the
+// enclosing transaction, and an error it returns aborts the whole transaction
+// once it propagates to the outermost call. Only the outermost transaction
+// commits. RollbackIf does not apply to flattened calls, as there is no
+// savepoint to roll back to. Helpers that promise to roll back only their own
+// work, such as Try, RunEach, WithDecision, WithOptimisticRetry,
+// WithCriticalSection, ChunkedImport and Saga steps, still use savepoints.
+// Outside a transaction the context behaves as usual.
+func WithFlatNesting(ctx context.Context) context.Context {
+	return context.WithValue(ctx, flatNestingKey, true)
+}
+
+// newChild links stx to the STX of the context it is started from and
+// returns it
+func newChild(ctx context.Context, stx *STX) *STX {
+	stx.depth = 1
+	stx.parent = fromContext(ctx)
+	stx.trace = traceFromContext(ctx)
+	defer func() { stx.trace.record(TraceBegin, "depth %d", stx.depth) }()
+
+	if stx.parent == nil {
+		stx.id = nextID()
+		stx.trackQueryErrors()
+		return stx
+	}
+
+	nested := IsTx(ctx)
+	if nested {
+		stx.depth = stx.parent.depth + 1
+		stx.id = stx.parent.id
+	} else {
+		stx.id = nextID()
+		stx.trackQueryErrors()
+	}
+
+	shared, _ := ctx.Value(sharedCallbacksKey).(bool)
+	stx.shareCallbacks = shared && nested
+	// Only a transaction is superseded by the transactions started from it:
+	// the STX of New, NewSharded or Bridge is shared by unrelated work
+	if nested && stx.parent.resolver == nil {
+		atomic.AddInt32(&stx.parent.children, 1)
+	} else {
+		stx.released = 1
+	}
+
+	stx.warnIfDeeplyNested(ctx)
+	return stx
+}
+
+// release marks the transaction as no longer active in its parent. It is
+// safe to call more than once.
+func (stx *STX) release() {
+	if stx.parent != nil && atomic.CompareAndSwapUint32(&stx.released, 0, 1) {
+		atomic.AddInt32(&stx.parent.children, -1)
+	}
+}
+
+// Begin starts a transaction and returns a context carrying it. If the context
+// is already in a transaction, a savepoint is created in it instead: Commit on
+// the returned context keeps the savepoint's work for the enclosing transaction
+// to commit, and Rollback only rolls back to the savepoint. The opts are
+// ignored in that case.
 func Begin(ctx context.Context, opts ...*sql.TxOptions) context.Context {
 	db := Current(ctx)
 	if db == nil {
 		return ctx
 	}
 
-	tx := db.Begin(opts...)
-	return context.WithValue(ctx, txContextKey, &STX{db: tx})
+	if IsTx(ctx) {
+		name := nextSavepointName()
+		// SavePoint records its error on the DB it is called on, which must
+		// not be the enclosing transaction's
+		if err := db.Session(&gorm.Session{}).SavePoint(name).Error; err != nil {
+			return context.WithValue(ctx, txContextKey, &STX{db: erroredDB(db, err), beginErr: err})
+		}
+		stx := newChild(ctx, &STX{db: db, owned: true, savepoint: name, txOptions: fromContext(ctx).txOptions})
+		stx.trackSavepoint()
+		txCtx := context.WithValue(ctx, txContextKey, stx)
+		runBeginHooks(txCtx)
+		return txCtx
+	}
+
+	var discard func()
+	if dedicated, _ := ctx.Value(dedicatedConnContextKey).(bool); dedicated {
+		connDB, release, err := acquireConn(ctx, db)
+		if err != nil {
+			return context.WithValue(ctx, txContextKey, &STX{db: erroredDB(db, err), beginErr: err})
+		}
+		db, discard = connDB, release
+	}
+
+	txOpts := settingsFromContext(ctx).txOptions(opts)
+	tx, err := configureTx(ctx, preparedDB(ctx, db).Begin(txOpts...))
+	if err != nil {
+		// The transaction began but failed to configure
+		tx.Rollback()
+	} else {
+		err = tx.Error
+	}
+	stx := &STX{db: tx, owned: true, txOptions: firstTxOptions(txOpts), beginErr: err, connDiscard: discard}
+	txCtx := context.WithValue(ctx, txContextKey, stx)
+	if err != nil {
+		stx.discardConn()
+	} else {
+		stx.tracked = true
+		atomic.AddInt64(&openTransactions, 1)
+		newChild(ctx, stx)
+		stx.startLifetimeTimer()
+		runBeginHooks(txCtx)
+	}
+	return txCtx
+}
+
+// erroredDB returns a handle on db carrying err, for contexts whose
+// transaction failed to begin: statements run through it fail with err
+// instead of running in the enclosing transaction or outside of any
+func erroredDB(db *gorm.DB, err error) *gorm.DB {
+	errored := db.Session(&gorm.Session{})
+	errored.AddError(err)
+	return errored
+}
+
+// BeginCancelable is like Begin, but also returns a cancel function that rolls
+// the transaction back and cancels the returned context. It lets code that
+// starts a long transaction hand out a way to abort it from elsewhere, even
+// while queries are running on it: Commit and Rollback then report
+// context.Canceled. Either Commit the transaction or call cancel to avoid
+// leaking the connection; deferring cancel is safe, as it does not roll back
+// a transaction that has already been committed or rolled back. Within a
+// transaction, where Begin creates a savepoint, cancel only cancels the
+// context and the savepoint is rolled back by the next Commit or Rollback.
+func BeginCancelable(ctx context.Context, opts ...*sql.TxOptions) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		return nil, func() {}
+	}
+
+	ctx, cancelCtx := context.WithCancel(ctx)
+	txCtx := Begin(ctx, opts...)
+
+	cancel := func() {
+		// Rolling back through the savepoint or the shared *gorm.DB would
+		// race with the owner's queries; abort on the raw connection like the
+		// lifetime timer instead
+		if stx := fromContext(txCtx); stx != nil && IsTx(txCtx) && OwnsTransaction(txCtx) && stx.savepoint == "" {
+			stx.abort(context.Canceled)
+		}
+		cancelCtx()
+	}
+	return txCtx, cancel
 }
 
 func Commit(ctx context.Context) error {
@@ -168,12 +958,53 @@ func Commit(ctx context.Context) error {
 		return nil
 	}
 
-	// Only commit if we're actually in a transaction
-	if !IsTx(ctx) {
+	// Only commit if we're actually in a transaction that stx started
+	if !IsTx(ctx) || !OwnsTransaction(ctx) {
 		return nil
 	}
 
-	return db.Commit().Error
+	// Committing on a cancelled context would fail on the dead connection
+	// with a driver error; roll back and report the cancellation instead
+	if err := ctx.Err(); err != nil {
+		Rollback(ctx)
+		return err
+	}
+
+	stx := fromContext(ctx)
+	if err := stx.finish(); err != nil {
+		return err
+	}
+
+	if err := stx.runPrepares(); err != nil {
+		Rollback(ctx)
+		return err
+	}
+
+	if err := warningLogged(db); err != nil {
+		Rollback(ctx)
+		return err
+	}
+
+	chaos := chaosFor(ctx)
+	if chaos == chaosRollback {
+		Rollback(ctx)
+		return ErrChaosRollback
+	}
+
+	// A savepoint's work is committed by the enclosing transaction
+	if stx.savepoint == "" {
+		defer stx.discardConn()
+		if err := db.Commit().Error; err != nil {
+			stx.end(TxRolledBack)
+			return err
+		}
+	}
+	stx.end(TxCommitted)
+
+	if chaos == chaosCommitError {
+		return ErrChaosCommitFailed
+	}
+	return nil
 }
 
 func Rollback(ctx context.Context) error {
@@ -182,29 +1013,258 @@ func Rollback(ctx context.Context) error {
 		return nil
 	}
 
-	// Only rollback if we're actually in a transaction
-	if !IsTx(ctx) {
+	// Only rollback if we're actually in a transaction that stx started
+	if !IsTx(ctx) || !OwnsTransaction(ctx) {
 		return nil
 	}
 
+	stx := fromContext(ctx)
+	if err := stx.finish(); err != nil {
+		return err
+	}
+
+	logFailedStatement(ctx, db, stx)
+	defer stx.end(TxRolledBack)
+	if stx.savepoint != "" {
+		return db.RollbackTo(stx.savepoint).Error
+	}
+
+	defer stx.discardConn()
 	return db.Rollback().Error
 }
 
+// firstTxOptions returns the options GORM uses out of opts
+func firstTxOptions(opts []*sql.TxOptions) *sql.TxOptions {
+	if len(opts) == 0 {
+		return nil
+	}
+	return opts[0]
+}
+
+// IsolationLevel returns the isolation level the current transaction was
+// explicitly started with. The bool is false when the context is not in a
+// transaction or the transaction uses the driver's default level.
+func IsolationLevel(ctx context.Context) (sql.IsolationLevel, bool) {
+	stx := fromContext(ctx)
+	if stx == nil || !IsTx(ctx) || stx.txOptions == nil || stx.txOptions.Isolation == sql.LevelDefault {
+		return sql.LevelDefault, false
+	}
+	return stx.txOptions.Isolation, true
+}
+
+// JoinDefer is like WithDefer, but joins the transaction in ctx instead of
+// starting a savepoint: if ctx is already in a transaction, it returns ctx
+// and a cleanup function that does nothing, leaving commit and rollback to
+// the outermost scope. Otherwise it starts a transaction with WithDefer. This
+// suits helpers that are usually called within a transaction but must also
+// work on their own.
+func JoinDefer(ctx context.Context, opts ...*sql.TxOptions) (context.Context, func(*error)) {
+	if IsTx(ctx) {
+		return ctx, func(*error) {}
+	}
+	return WithDefer(ctx, opts...)
+}
+
+// WithDeferAbort is like WithDefer, but also returns an abort function that
+// marks the transaction rollback-only: the cleanup function then rolls the
+// transaction back even if the block returns a nil error, and OnSuccess
+// callbacks do not fire. This lets code decide midway to discard its work
+// without turning that decision into an error.
+func WithDeferAbort(ctx context.Context, opts ...*sql.TxOptions) (context.Context, func(*error), func()) {
+	txCtx, cleanup := WithDefer(ctx, opts...)
+
+	abort := func() {
+		if stx := fromContext(txCtx); stx != nil {
+			stx.mu.Lock()
+			stx.rollbackOnly = true
+			stx.mu.Unlock()
+		}
+	}
+	return txCtx, cleanup, abort
+}
+
+// isRollbackOnly reports whether the transaction was aborted with the abort
+// function from WithDeferAbort
+func (stx *STX) isRollbackOnly() bool {
+	stx.mu.RLock()
+	defer stx.mu.RUnlock()
+	return stx.rollbackOnly
+}
+
+// IsReadOnly reports whether the current transaction was started with
+// ReadOnly set in its TxOptions, for example through TxBuilder.ReadOnly or
+// TxSettings. Nested transactions report the enclosing transaction's mode.
+func IsReadOnly(ctx context.Context) bool {
+	stx := fromContext(ctx)
+	return stx != nil && IsTx(ctx) && stx.txOptions != nil && stx.txOptions.ReadOnly
+}
+
+// CommitIf commits the transaction started with Begin if predicate returns
+// true and rolls it back otherwise. OnSuccess callbacks registered on the
+// transaction run only after a successful commit. A nil predicate rolls back.
+func CommitIf(ctx context.Context, predicate func() bool) error {
+	if predicate == nil || !predicate() {
+		return Rollback(ctx)
+	}
+
+	if err := Commit(ctx); err != nil {
+		return err
+	}
+
+	if OwnsTransaction(ctx) {
+		fromContext(ctx).runCallbacks()
+	}
+	return nil
+}
+
+// CommitAndContinue commits the transaction started with Begin, runs its
+// OnSuccess callbacks like CommitIf, and begins a fresh transaction on the
+// same database with the same options. It returns the context of the new
+// transaction, derived from ctx so it keeps its values. This lets bulk
+// processors checkpoint their work periodically to release locks:
+//
+//	txCtx := stx.Begin(ctx)
+//	for i, item := range items {
+//	    ...
+//	    if i%1000 == 999 {
+//	        if txCtx, err = stx.CommitAndContinue(txCtx); err != nil {
+//	            return err
+//	        }
+//	    }
+//	}
+//	return stx.Commit(txCtx)
+//
+// For a savepoint, the savepoint is released and a new one is created in the
+// enclosing transaction. If the commit fails, ctx and the error are returned
+// and no new transaction is begun. It returns gorm.ErrInvalidTransaction if
+// ctx holds no transaction started by stx.
+func CommitAndContinue(ctx context.Context) (context.Context, error) {
+	if !OwnsTransaction(ctx) {
+		return ctx, gorm.ErrInvalidTransaction
+	}
+
+	stx := fromContext(ctx)
+	if err := CommitIf(ctx, func() bool { return true }); err != nil {
+		return ctx, err
+	}
+
+	// Begin from the context the transaction was started from, shadowing
+	// the committed transaction
+	baseCtx := context.WithValue(ctx, txContextKey, stx.parent)
+	var opts []*sql.TxOptions
+	if stx.txOptions != nil {
+		opts = append(opts, stx.txOptions)
+	}
+	txCtx := Begin(baseCtx, opts...)
+	return txCtx, BeginError(txCtx)
+}
+
 func IsTx(ctx context.Context) bool {
-	db := Current(ctx)
-	if db == nil {
+	stx := fromContext(ctx)
+	if stx == nil || stx.beginErr != nil {
 		return false
 	}
 
+	db := stx.resolveDB(ctx)
+	if db == nil {
+		return false
+	}
 	return db.Statement.ConnPool != nil &&
 		db.Statement.ConnPool != db.Statement.DB.ConnPool
 }
 
+// DriverName returns the name of the GORM dialector of the database in ctx,
+// such as "postgres", "mysql" or "sqlite", so hooks and helpers can adapt to
+// the driver. It returns an empty string if ctx holds no database.
+func DriverName(ctx context.Context) string {
+	stx := fromContext(ctx)
+	if stx == nil {
+		return ""
+	}
+
+	db := stx.resolveDB(ctx)
+	if db == nil || db.Dialector == nil {
+		return ""
+	}
+	return db.Dialector.Name()
+}
+
+// SupportsTransactions reports whether the database in ctx can run
+// transactions: its connection pool can begin them, or ctx is already in one.
+// Code can use it to degrade gracefully on dialectors or custom connection
+// pools without transaction support, where Begin and WithTransaction fail
+// with gorm.ErrInvalidTransaction. It returns false if ctx holds no database.
+func SupportsTransactions(ctx context.Context) bool {
+	stx := fromContext(ctx)
+	if stx == nil {
+		return false
+	}
+
+	db := stx.resolveDB(ctx)
+	if db == nil {
+		return false
+	}
+
+	switch db.Statement.ConnPool.(type) {
+	case gorm.TxBeginner, gorm.ConnPoolBeginner, gorm.TxCommitter:
+		return true
+	}
+	return false
+}
+
+// SameTransaction reports whether a and b carry the same stx state, for
+// example because b was derived from a with context.WithTimeout. It helps
+// tests assert that a derived context did not lose the transaction. Contexts
+// without stx state never match.
+func SameTransaction(a, b context.Context) bool {
+	stxA := fromContext(a)
+	return stxA != nil && stxA == fromContext(b)
+}
+
+// OwnsTransaction reports whether the transaction in the context was started
+// by stx (via Begin, WithTransaction or WithDefer). It returns false for
+// transactions that were created elsewhere and passed in with New, in which
+// case Commit and Rollback leave the transaction to its owner.
+func OwnsTransaction(ctx context.Context) bool {
+	stx := fromContext(ctx)
+	if stx == nil {
+		return false
+	}
+
+	return stx.owned && IsTx(ctx)
+}
+
+// BeginError returns the error that beginning the transaction in the context
+// failed with, or nil if it began successfully or the context holds no
+// transaction started by Begin or WithDefer. IsTx reports false for contexts
+// whose transaction failed to begin, and statements run on Current fail with
+// the error rather than reaching the enclosing transaction or the database.
+func BeginError(ctx context.Context) error {
+	stx := fromContext(ctx)
+	if stx == nil {
+		return nil
+	}
+	return stx.beginErr
+}
+
 // IsTransaction is deprecated, use IsTx instead
 func IsTransaction(ctx context.Context) bool {
 	return IsTx(ctx)
 }
 
+// SetStrictDefer controls what WithDefer does with a context that holds no
+// database. By default it is lenient: it returns the context unchanged and a
+// cleanup that does nothing, so the code runs without a transaction. In strict
+// mode the cleanup sets *err to ErrNoDatabase instead, surfacing the missing
+// New call. The setting applies to cleanups that run after the call.
+func SetStrictDefer(strict bool) {
+	var v int32
+	if strict {
+		v = 1
+	}
+	atomic.StoreInt32(&strictDefer, v)
+}
+
 // WithDefer begins a transaction and returns a context and cleanup function.
 // The cleanup function should be called with defer and handles panic recovery
 // and automatic commit/rollback based on the error state.
@@ -213,6 +1273,29 @@ func IsTransaction(ctx context.Context) bool {
 // commit, making this ideal for triggering events, notifications, or other side
 // effects that should only occur when the transaction is successfully persisted.
 //
+// When ctx is already in a transaction, WithDefer works on a savepoint (see
+// Begin): a failing inner block only rolls back its own work and leaves the
+// enclosing transaction usable.
+//
+// The cleanup function tolerates a nil error pointer: the transaction is
+// still committed, or rolled back on panic, but errors cannot be reported.
+//
+// The cleanup runs in fixed phases, and the first phase that applies ends
+// the transaction exactly once:
+//  1. recover: a panic rolls back and sets *err to the panic error, replacing
+//     any error already set, so a panic during error handling is not lost
+//  2. error: a non-nil *err rolls back
+//  3. begin: if the transaction could not begin, *err is set to that error;
+//     without a database it is set to ErrNoDatabase in strict mode only, see
+//     SetStrictDefer
+//  4. abort: a transaction aborted with WithDeferAbort rolls back
+//  5. commit: otherwise the transaction commits, or rolls back if its context
+//     is already canceled, and a failure is reported in *err
+//  6. callbacks: after a successful commit, OnSuccess callbacks run
+//
+// Only the first call of the cleanup function has an effect; calling it again
+// is a no-op that leaves *err untouched.
+//
 // Example usage:
 //   func createUser(ctx context.Context, user *User) (err error) {
 //       txCtx, cleanup := stx.WithDefer(ctx)
@@ -227,10 +1310,28 @@ func IsTransaction(ctx context.Context) bool {
 //       return stx.Current(txCtx).Create(user).Error
 //   }
 func WithDefer(ctx context.Context, opts ...*sql.TxOptions) (context.Context, func(*error)) {
+	cancel := context.CancelFunc(func() {})
+	if !IsTx(ctx) {
+		ctx, cancel = withDefaultTimeout(ctx)
+	}
 	txCtx := Begin(ctx, opts...)
-	
+	var cleanedUp uint32
+
 	cleanup := func(err *error) {
-		if r := recover(); r != nil {
+		// recover only works when called directly by the deferred function,
+		// so it cannot move into the guard below
+		r := recover()
+		if !atomic.CompareAndSwapUint32(&cleanedUp, 0, 1) {
+			// A panic recovered here is not the transaction's to handle
+			if r != nil {
+				panic(r)
+			}
+			return
+		}
+		defer cancel()
+		
+		// The phases below are documented on WithDefer; keep them in sync
+		if r != nil {
 			Rollback(txCtx)
 			if err != nil {
 				*err = panicError(r)
@@ -239,33 +1340,47 @@ func WithDefer(ctx context.Context, opts ...*sql.TxOptions) (context.Context, fu
 		}
 		
 		if err != nil && *err != nil {
+			// The error may be a symptom of the transaction being aborted
+			// underneath, in which case the timeout is the real cause
+			if rollbackErr := Rollback(txCtx); errors.Is(rollbackErr, ErrTransactionTimeout) {
+				*err = rollbackErr
+			}
+			return
+		}
+		
+		if Current(txCtx) == nil {
+			if err != nil && atomic.LoadInt32(&strictDefer) != 0 {
+				*err = ErrNoDatabase
+			}
+			return
+		}
+		
+		if beginErr := BeginError(txCtx); beginErr != nil {
+			if err != nil {
+				*err = newSTXError("failed to begin transaction", beginErr)
+			}
+			return
+		}
+		
+		if stx := fromContext(txCtx); stx != nil && stx.isRollbackOnly() {
 			Rollback(txCtx)
 			return
 		}
 		
 		if commitErr := Commit(txCtx); commitErr != nil {
 			if err != nil {
-				*err = newSTXError("failed to commit transaction", commitErr)
+				if errors.Is(commitErr, ErrTransactionTimeout) {
+					*err = commitErr
+				} else {
+					*err = newSTXError("failed to commit transaction", commitErr)
+				}
 			}
 			return
 		}
 		
 		// Execute success callbacks after successful commit
-		if txCtx != nil {
-			if val := txCtx.Value(txContextKey); val != nil {
-				if stx, ok := val.(*STX); ok && stx != nil {
-					stx.mu.RLock()
-					callbacks := make([]func(), len(stx.callbacks))
-					copy(callbacks, stx.callbacks)
-					stx.mu.RUnlock()
-					
-					for _, callback := range callbacks {
-						if callback != nil {
-							callback()
-						}
-					}
-				}
-			}
+		if stx := fromContext(txCtx); stx != nil {
+			stx.runCallbacks()
 		}
 	}
 	
@@ -2,10 +2,14 @@ package stx
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -17,8 +21,25 @@ type TestModel struct {
 	Name string `gorm:"not null"`
 }
 
+type testDomainError struct {
+	Code int
+}
+
+func (e *testDomainError) Error() string {
+	return fmt.Sprintf("domain error %d", e.Code)
+}
+
+var testDBCounter int64
+
+// testDSN names a fresh in-memory SQLite database so tests don't see each
+// other's rows
+func testDSN() string {
+	return fmt.Sprintf("file:stx_test_%d?mode=memory&cache=shared", atomic.AddInt64(&testDBCounter, 1))
+}
+
+// setupTestDB opens a fresh in-memory database
 func setupTestDB(t *testing.T) *gorm.DB {
-	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{
+	db, err := gorm.Open(sqlite.Open(testDSN()), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
 	})
 	if err != nil {
@@ -33,6 +54,142 @@ func setupTestDB(t *testing.T) *gorm.DB {
 	return db
 }
 
+// capturingLogger is a GORM logger that records the messages logged to it
+type capturingLogger struct {
+	mu       sync.Mutex
+	warnings []string
+	errors   []string
+}
+
+func (l *capturingLogger) LogMode(logger.LogLevel) logger.Interface { return l }
+
+func (l *capturingLogger) Info(context.Context, string, ...interface{}) {}
+
+func (l *capturingLogger) Warn(_ context.Context, msg string, data ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warnings = append(l.warnings, fmt.Sprintf(msg, data...))
+}
+
+func (l *capturingLogger) Error(_ context.Context, msg string, data ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, fmt.Sprintf(msg, data...))
+}
+
+func (l *capturingLogger) Trace(context.Context, time.Time, func() (string, int64), error) {}
+
+// warningCount returns the number of warnings logged so far
+func (l *capturingLogger) warningCount() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.warnings)
+}
+
+// testConnPool wraps a *sql.DB, recording the options transactions are begun
+// with, since SQLite itself ignores them, and counting the statements
+// prepared on those transactions, their commits and their rollbacks
+type testConnPool struct {
+	*sql.DB
+	// commit, when set, replaces committing the underlying transaction
+	commit func(tx *sql.Tx) error
+
+	mu   sync.Mutex
+	opts []*sql.TxOptions
+
+	prepared   int64
+	committed  int64
+	rolledBack int64
+}
+
+func (p *testConnPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	p.mu.Lock()
+	p.opts = append(p.opts, opts)
+	p.mu.Unlock()
+
+	tx, err := p.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &testTx{Tx: tx, pool: p}, nil
+}
+
+func (p *testConnPool) GetDBConn() (*sql.DB, error) {
+	return p.DB, nil
+}
+
+// lastOpts returns the options of the most recently begun transaction
+func (p *testConnPool) lastOpts() *sql.TxOptions {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.opts) == 0 {
+		return nil
+	}
+	return p.opts[len(p.opts)-1]
+}
+
+type testTx struct {
+	*sql.Tx
+	pool *testConnPool
+}
+
+func (tx *testTx) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	atomic.AddInt64(&tx.pool.prepared, 1)
+	return tx.Tx.PrepareContext(ctx, query)
+}
+
+func (tx *testTx) Commit() error {
+	atomic.AddInt64(&tx.pool.committed, 1)
+	if tx.pool.commit != nil {
+		return tx.pool.commit(tx.Tx)
+	}
+	return tx.Tx.Commit()
+}
+
+func (tx *testTx) Rollback() error {
+	atomic.AddInt64(&tx.pool.rolledBack, 1)
+	return tx.Tx.Rollback()
+}
+
+// errCommitFailed is returned by commits of a pool set up with failCommits
+var errCommitFailed = errors.New("commit failed")
+
+// failCommits makes every commit roll back and fail, simulating a commit
+// rejected by the database
+func failCommits(p *testConnPool) {
+	p.commit = func(tx *sql.Tx) error {
+		tx.Rollback()
+		return errCommitFailed
+	}
+}
+
+// setupPoolTestDB is like setupTestDB but begins transactions through a
+// testConnPool, configured by hooks
+func setupPoolTestDB(t *testing.T, hooks ...func(*testConnPool)) (*gorm.DB, *testConnPool) {
+	sqlDB, err := sql.Open("sqlite3", testDSN())
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	pool := &testConnPool{DB: sqlDB}
+	for _, hook := range hooks {
+		hook(pool)
+	}
+	db, err := gorm.Open(sqlite.Dialector{Conn: pool}, &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to connect database: %v", err)
+	}
+
+	if err := db.AutoMigrate(&TestModel{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	return db, pool
+}
+
 func TestNew(t *testing.T) {
 	db := setupTestDB(t)
 	ctx := context.Background()
@@ -48,6 +205,56 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNewIdempotent(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	t.Run("same DB", func(t *testing.T) {
+		again := New(ctx, db)
+		if again != ctx {
+			t.Error("expected New with the same DB to return the context unchanged")
+		}
+		if Current(again) != Current(ctx) {
+			t.Error("expected Current to be unchanged")
+		}
+	})
+
+	t.Run("within transaction", func(t *testing.T) {
+		called := false
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			again := New(txCtx, Current(txCtx))
+			if !IsTx(again) {
+				t.Error("expected context to stay transactional")
+			}
+			if Current(again) != Current(txCtx) {
+				t.Error("expected Current to return the transaction")
+			}
+			OnSuccess(again, func() { called = true })
+			if called {
+				t.Error("expected callback to wait for the commit")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if !called {
+			t.Error("expected callback to run after commit")
+		}
+	})
+
+	t.Run("different DB", func(t *testing.T) {
+		other := setupTestDB(t)
+		replaced := New(ctx, other)
+		if Current(replaced) != other {
+			t.Error("expected Current to return the new DB")
+		}
+		if Current(ctx) != db {
+			t.Error("expected the original context to keep its DB")
+		}
+	})
+}
+
 func TestGetCurrent(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -467,6 +674,69 @@ func TestWithDefer(t *testing.T) {
 		}
 	})
 
+	t.Run("panic with error value", func(t *testing.T) {
+		domainErr := &testDomainError{Code: 42}
+
+		err := func() (err error) {
+			_, cleanup := WithDefer(ctx)
+			defer cleanup(&err)
+
+			panic(domainErr)
+		}()
+
+		if err != domainErr {
+			t.Fatalf("expected panic error to be returned as-is, got: %v", err)
+		}
+
+		var target *testDomainError
+		if !errors.As(err, &target) || target.Code != 42 {
+			t.Errorf("expected errors.As to find domain error, got: %v", err)
+		}
+	})
+
+	t.Run("nested defer uses savepoint", func(t *testing.T) {
+		innerErr := errors.New("inner failure")
+
+		err := func() (err error) {
+			outerCtx, cleanup := WithDefer(ctx)
+			defer cleanup(&err)
+
+			outer := TestModel{Name: "defer-outer-savepoint"}
+			if err := Current(outerCtx).Create(&outer).Error; err != nil {
+				return err
+			}
+
+			gotErr := func() (err error) {
+				innerCtx, cleanup := WithDefer(outerCtx)
+				defer cleanup(&err)
+
+				inner := TestModel{Name: "defer-inner-savepoint"}
+				if err := Current(innerCtx).Create(&inner).Error; err != nil {
+					return err
+				}
+				return innerErr
+			}()
+			if gotErr != innerErr {
+				return fmt.Errorf("expected inner error, got: %v", gotErr)
+			}
+
+			return nil
+		}()
+		if err != nil {
+			t.Fatalf("outer transaction failed: %v", err)
+		}
+
+		var outerCount, innerCount int64
+		db.Model(&TestModel{}).Where("name = ?", "defer-outer-savepoint").Count(&outerCount)
+		db.Model(&TestModel{}).Where("name = ?", "defer-inner-savepoint").Count(&innerCount)
+		if outerCount != 1 {
+			t.Errorf("expected outer record to be committed, got %d", outerCount)
+		}
+		if innerCount != 0 {
+			t.Errorf("expected inner record to be rolled back, got %d", innerCount)
+		}
+	})
+
 	t.Run("defer with nil context", func(t *testing.T) {
 		txCtx, cleanup := WithDefer(nil)
 		if txCtx != nil {
@@ -493,6 +763,40 @@ func TestWithDefer(t *testing.T) {
 		}
 	})
 
+	t.Run("strict defer without DB", func(t *testing.T) {
+		SetStrictDefer(true)
+		defer SetStrictDefer(false)
+
+		_, cleanup := WithDefer(context.Background())
+		var err error
+		cleanup(&err)
+		if !errors.Is(err, ErrNoDatabase) {
+			t.Errorf("expected ErrNoDatabase in strict mode, got: %v", err)
+		}
+
+		_, cleanup = WithDefer(nil)
+		err = nil
+		cleanup(&err)
+		if !errors.Is(err, ErrNoDatabase) {
+			t.Errorf("expected ErrNoDatabase for nil context in strict mode, got: %v", err)
+		}
+
+		errFailed := errors.New("failed")
+		_, cleanup = WithDefer(context.Background())
+		err = errFailed
+		cleanup(&err)
+		if err != errFailed {
+			t.Errorf("expected the function's error to be kept, got: %v", err)
+		}
+
+		txCtx, cleanup := WithDefer(ctx)
+		err = Current(txCtx).Create(&TestModel{Name: "strict"}).Error
+		cleanup(&err)
+		if err != nil {
+			t.Errorf("expected strict mode to leave transactions alone, got: %v", err)
+		}
+	})
+
 	t.Run("defer transaction status", func(t *testing.T) {
 		txCtx, cleanup := WithDefer(ctx)
 		defer func() {
@@ -511,79 +815,38 @@ func TestWithDefer(t *testing.T) {
 	})
 }
 
-func TestOnSuccess(t *testing.T) {
-	db := setupTestDB(t)
+func TestWithDeferPanicAfterError(t *testing.T) {
+	db, pool := setupPoolTestDB(t)
 	ctx := New(context.Background(), db)
 
-	t.Run("basic OnSuccess with successful transaction", func(t *testing.T) {
-		var callbackExecuted bool
-		
-		err := func() (err error) {
-			txCtx, cleanup := WithDefer(ctx)
-			defer cleanup(&err)
-
-			// Register success callback
-			OnSuccess(txCtx, func() {
-				callbackExecuted = true
-			})
-
-			// Create test model
-			model := TestModel{Name: "success-test"}
-			return Current(txCtx).Create(&model).Error
-		}()
-
-		if err != nil {
-			t.Fatalf("transaction failed: %v", err)
-		}
-
-		if !callbackExecuted {
-			t.Error("expected callback to be executed after successful transaction")
-		}
-	})
-
-	t.Run("OnSuccess with transaction rollback", func(t *testing.T) {
-		var callbackExecuted bool
-		
-		err := func() (err error) {
-			txCtx, cleanup := WithDefer(ctx)
-			defer cleanup(&err)
-
-			// Register success callback
-			OnSuccess(txCtx, func() {
-				callbackExecuted = true
-			})
-
-			// Create test model
-			model := TestModel{Name: "rollback-test"}
-			if err := Current(txCtx).Create(&model).Error; err != nil {
-				return err
-			}
-
-			// Force rollback
-			return errors.New("forced rollback")
-		}()
+	err := func() (err error) {
+		txCtx, cleanup := WithDefer(ctx)
+		defer cleanup(&err)
 
-		if err == nil {
-			t.Fatal("expected error to trigger rollback")
+		if err = Current(txCtx).Create(&TestModel{Name: "partial"}).Error; err != nil {
+			return err
 		}
+		err = errors.New("handled error")
+		panic("panic during error handling")
+	}()
 
-		if callbackExecuted {
-			t.Error("callback should not be executed after rollback")
-		}
-	})
+	if err == nil || !strings.Contains(err.Error(), "panic during error handling") {
+		t.Errorf("expected the panic to take precedence, got %v", err)
+	}
+	if n := atomic.LoadInt64(&pool.rolledBack); n != 1 {
+		t.Errorf("expected exactly one rollback, got %d", n)
+	}
 
-	t.Run("OnSuccess without transaction context", func(t *testing.T) {
-		var callbackExecuted bool
-		
-		// Call OnSuccess with plain context (no STX)
-		OnSuccess(context.Background(), func() {
-			callbackExecuted = true
-		})
+	var count int64
+	db.Model(&TestModel{}).Where("name = ?", "partial").Count(&count)
+	if count != 0 {
+		t.Errorf("expected the insert to be rolled back, got %d rows", count)
+	}
+}
 
-		if !callbackExecuted {
-			t.Error("expected callback to execute immediately without transaction context")
-		}
-	})
+func TestOnSuccess(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
 
 	t.Run("OnSuccess with nil context", func(t *testing.T) {
 		var callbackExecuted bool
@@ -618,284 +881,1887 @@ func TestOnSuccess(t *testing.T) {
 		}
 	})
 
-	t.Run("multiple OnSuccess callbacks", func(t *testing.T) {
-		var executionOrder []int
+	t.Run("OnSuccess with invalid context value", func(t *testing.T) {
+		var callbackExecuted bool
 		
-		err := func() (err error) {
-			txCtx, cleanup := WithDefer(ctx)
-			defer cleanup(&err)
-
-			// Register multiple callbacks
-			OnSuccess(txCtx, func() {
-				executionOrder = append(executionOrder, 1)
-			})
-			OnSuccess(txCtx, func() {
-				executionOrder = append(executionOrder, 2)
-			})
-			OnSuccess(txCtx, func() {
-				executionOrder = append(executionOrder, 3)
-			})
-
-			model := TestModel{Name: "multiple-callbacks-test"}
-			return Current(txCtx).Create(&model).Error
-		}()
-
-		if err != nil {
-			t.Fatalf("transaction failed: %v", err)
-		}
-
-		expectedOrder := []int{1, 2, 3}
-		if len(executionOrder) != len(expectedOrder) {
-			t.Errorf("expected %d callbacks executed, got %d", len(expectedOrder), len(executionOrder))
-		}
+		// Create context with invalid STX value
+		invalidCtx := context.WithValue(context.Background(), txContextKey, "invalid")
+		
+		OnSuccess(invalidCtx, func() {
+			callbackExecuted = true
+		})
 
-		for i, expected := range expectedOrder {
-			if i >= len(executionOrder) || executionOrder[i] != expected {
-				t.Errorf("expected callback order %v, got %v", expectedOrder, executionOrder)
-				break
-			}
+		if !callbackExecuted {
+			t.Error("expected callback to execute immediately with invalid context value")
 		}
 	})
 
-	t.Run("OnSuccess with panic recovery", func(t *testing.T) {
+	t.Run("OnSuccess callbacks with database operations", func(t *testing.T) {
+		var initialCount int64
+		db.Model(&TestModel{}).Count(&initialCount)
+
 		var callbackExecuted bool
+		var callbackDbValue *TestModel
 		
 		err := func() (err error) {
 			txCtx, cleanup := WithDefer(ctx)
 			defer cleanup(&err)
 
-			// Register success callback
-			OnSuccess(txCtx, func() {
-				callbackExecuted = true
-			})
-
 			// Create test model
-			model := TestModel{Name: "panic-test"}
+			model := TestModel{Name: "callback-db-test"}
 			if err := Current(txCtx).Create(&model).Error; err != nil {
 				return err
 			}
 
-			// Force panic
-			panic("test panic")
+			// Register callback that reads from database
+			OnSuccess(txCtx, func() {
+				callbackExecuted = true
+				// Read the committed data
+				var foundModel TestModel
+				if err := db.Where("name = ?", "callback-db-test").First(&foundModel).Error; err == nil {
+					callbackDbValue = &foundModel
+				}
+			})
+
+			return nil
 		}()
 
-		if err == nil {
-			t.Fatal("expected error from panic recovery")
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
 		}
 
-		if callbackExecuted {
-			t.Error("callback should not be executed after panic rollback")
+		if !callbackExecuted {
+			t.Error("expected callback to be executed")
+		}
+
+		if callbackDbValue == nil {
+			t.Error("expected callback to read committed data from database")
+		} else if callbackDbValue.Name != "callback-db-test" {
+			t.Errorf("expected callback to read correct data, got name: %s", callbackDbValue.Name)
 		}
 	})
+}
 
-	t.Run("concurrent OnSuccess callback registration", func(t *testing.T) {
-		var wg sync.WaitGroup
-		var executionCount int
-		var mu sync.Mutex
-		
-		err := func() (err error) {
-			txCtx, cleanup := WithDefer(ctx)
-			defer cleanup(&err)
+func TestOwnsTransaction(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
 
-			// Register callbacks from multiple goroutines
-			const numCallbacks = 10
-			for i := 0; i < numCallbacks; i++ {
-				wg.Add(1)
-				go func() {
-					defer wg.Done()
-					OnSuccess(txCtx, func() {
-						mu.Lock()
-						executionCount++
-						mu.Unlock()
-					})
-				}()
-			}
+	t.Run("not in transaction", func(t *testing.T) {
+		if OwnsTransaction(ctx) {
+			t.Error("expected OwnsTransaction to return false outside a transaction")
+		}
+	})
 
-			wg.Wait()
+	t.Run("transaction started by Begin", func(t *testing.T) {
+		txCtx := Begin(ctx)
+		defer Rollback(txCtx)
 
-			model := TestModel{Name: "concurrent-callbacks-test"}
-			return Current(txCtx).Create(&model).Error
-		}()
+		if !OwnsTransaction(txCtx) {
+			t.Error("expected OwnsTransaction to return true after Begin")
+		}
+	})
 
+	t.Run("transaction started by WithTransaction", func(t *testing.T) {
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			if !OwnsTransaction(txCtx) {
+				t.Error("expected OwnsTransaction to return true inside WithTransaction")
+			}
+			return nil
+		})
 		if err != nil {
 			t.Fatalf("transaction failed: %v", err)
 		}
-
-		if executionCount != 10 {
-			t.Errorf("expected 10 callbacks executed, got %d", executionCount)
-		}
 	})
 
-	t.Run("OnSuccess callback execution order preservation", func(t *testing.T) {
-		var executionOrder []int
-		var mu sync.Mutex
-		
-		err := func() (err error) {
-			txCtx, cleanup := WithDefer(ctx)
-			defer cleanup(&err)
+	t.Run("externally begun transaction", func(t *testing.T) {
+		tx := db.Begin()
+		defer tx.Rollback()
 
-			// Register callbacks in specific order
-			for i := 1; i <= 5; i++ {
-				id := i
-				OnSuccess(txCtx, func() {
-					mu.Lock()
-					executionOrder = append(executionOrder, id)
-					mu.Unlock()
-				})
-			}
+		txCtx := New(context.Background(), tx)
+		if !IsTx(txCtx) {
+			t.Fatal("expected IsTx to return true for external transaction")
+		}
+		if OwnsTransaction(txCtx) {
+			t.Error("expected OwnsTransaction to return false for external transaction")
+		}
 
-			model := TestModel{Name: "order-preservation-test"}
-			return Current(txCtx).Create(&model).Error
-		}()
+		model := TestModel{Name: "external-owned-test"}
+		if err := Current(txCtx).Create(&model).Error; err != nil {
+			t.Fatalf("failed to create model: %v", err)
+		}
 
-		if err != nil {
-			t.Fatalf("transaction failed: %v", err)
+		// Commit must leave a transaction it doesn't own untouched
+		if err := Commit(txCtx); err != nil {
+			t.Fatalf("expected nil from Commit, got: %v", err)
+		}
+		if err := tx.Rollback().Error; err != nil {
+			t.Fatalf("expected external transaction to still be open, got: %v", err)
 		}
 
-		expectedOrder := []int{1, 2, 3, 4, 5}
-		if len(executionOrder) != len(expectedOrder) {
-			t.Errorf("expected %d callbacks, got %d", len(expectedOrder), len(executionOrder))
+		var count int64
+		db.Model(&TestModel{}).Where("name = ?", "external-owned-test").Count(&count)
+		if count != 0 {
+			t.Errorf("expected 0 records after external rollback, got %d", count)
 		}
+	})
 
-		for i, expected := range expectedOrder {
-			if i >= len(executionOrder) || executionOrder[i] != expected {
-				t.Errorf("expected callback order %v, got %v", expectedOrder, executionOrder)
-				break
-			}
+	t.Run("nil context", func(t *testing.T) {
+		if OwnsTransaction(nil) {
+			t.Error("expected OwnsTransaction to return false for nil context")
 		}
 	})
+}
 
-	t.Run("OnSuccess with invalid context value", func(t *testing.T) {
-		var callbackExecuted bool
-		
-		// Create context with invalid STX value
-		invalidCtx := context.WithValue(context.Background(), txContextKey, "invalid")
-		
-		OnSuccess(invalidCtx, func() {
-			callbackExecuted = true
+func TestAdopt(t *testing.T) {
+	db := setupTestDB(t)
+
+	tx := db.Begin()
+	txCtx, done := Adopt(context.Background(), tx)
+
+	if Current(txCtx) != tx {
+		t.Error("expected Current to return the adopted transaction")
+	}
+	if !IsTx(txCtx) {
+		t.Error("expected IsTx to return true for adopted transaction")
+	}
+	if OwnsTransaction(txCtx) {
+		t.Error("expected OwnsTransaction to return false for adopted transaction")
+	}
+
+	var outerCallbackExecuted, innerCallbackExecuted bool
+	OnSuccess(txCtx, func() {
+		outerCallbackExecuted = true
+	})
+	events := Watch(txCtx)
+
+	err := WithTransaction(txCtx, func(innerCtx context.Context) error {
+		OnSuccess(innerCtx, func() {
+			innerCallbackExecuted = true
 		})
+		model := TestModel{Name: "adopted-test"}
+		return Current(innerCtx).Create(&model).Error
+	})
+	if err != nil {
+		t.Fatalf("nested transaction failed: %v", err)
+	}
+	if !innerCallbackExecuted {
+		t.Error("expected nested callback to be executed")
+	}
 
-		if !callbackExecuted {
-			t.Error("expected callback to execute immediately with invalid context value")
+	// stx must not commit or roll back a transaction it doesn't own
+	if err := Commit(txCtx); err != nil {
+		t.Fatalf("expected nil from Commit, got: %v", err)
+	}
+	if err := Rollback(txCtx); err != nil {
+		t.Fatalf("expected nil from Rollback, got: %v", err)
+	}
+	if outerCallbackExecuted {
+		t.Error("expected callback to wait for the caller's commit")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		t.Fatalf("expected caller to commit adopted transaction, got: %v", err)
+	}
+	done(true)
+	done(true)
+
+	if !outerCallbackExecuted {
+		t.Error("expected callback to run once the caller reported the commit")
+	}
+	<-events // TxBegun
+	if event := <-events; event != TxCommitted {
+		t.Errorf("expected Watch to report the commit, got %v", event)
+	}
+
+	var count int64
+	db.Model(&TestModel{}).Where("name = ?", "adopted-test").Count(&count)
+	if count != 1 {
+		t.Errorf("expected 1 record after caller commit, got %d", count)
+	}
+
+	t.Run("rolled back", func(t *testing.T) {
+		tx := db.Begin()
+		txCtx, done := Adopt(context.Background(), tx)
+
+		called := false
+		OnSuccess(txCtx, func() { called = true })
+		tx.Rollback()
+		done(false)
+		done(true)
+
+		if called {
+			t.Error("expected callback to be discarded after a rollback")
 		}
 	})
+}
 
-	t.Run("OnSuccess callbacks with database operations", func(t *testing.T) {
-		var initialCount int64
-		db.Model(&TestModel{}).Count(&initialCount)
+func TestRequestID(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := WithRequestID(New(context.Background(), db), "req-123")
 
-		var callbackExecuted bool
-		var callbackDbValue *TestModel
-		
+	t.Run("read from base context", func(t *testing.T) {
+		if got := RequestID(ctx); got != "req-123" {
+			t.Errorf("expected request ID %q, got %q", "req-123", got)
+		}
+	})
+
+	t.Run("flows into committed transaction", func(t *testing.T) {
+		var callbackID string
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			OnSuccess(txCtx, func() {
+				callbackID = RequestID(txCtx)
+			})
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if callbackID != "req-123" {
+			t.Errorf("expected request ID %q in callback, got %q", "req-123", callbackID)
+		}
+	})
+
+	t.Run("flows into rolled back transaction", func(t *testing.T) {
+		var txID string
 		err := func() (err error) {
 			txCtx, cleanup := WithDefer(ctx)
 			defer cleanup(&err)
 
-			// Create test model
-			model := TestModel{Name: "callback-db-test"}
-			if err := Current(txCtx).Create(&model).Error; err != nil {
-				return err
-			}
+			txID = RequestID(txCtx)
+			return errors.New("forced rollback")
+		}()
+		if err == nil {
+			t.Fatal("expected error to trigger rollback")
+		}
+		if txID != "req-123" {
+			t.Errorf("expected request ID %q in transaction, got %q", "req-123", txID)
+		}
+	})
 
-			// Register callback that reads from database
+	t.Run("missing request ID", func(t *testing.T) {
+		if got := RequestID(context.Background()); got != "" {
+			t.Errorf("expected empty request ID, got %q", got)
+		}
+		if got := RequestID(nil); got != "" {
+			t.Errorf("expected empty request ID for nil context, got %q", got)
+		}
+	})
+}
+
+func TestRollbackIf(t *testing.T) {
+	db := setupTestDB(t)
+	errSoftFail := errors.New("soft fail")
+	softCtx := func() context.Context {
+		return RollbackIf(New(context.Background(), db), func(err error) bool {
+			return errors.Is(err, errSoftFail)
+		})
+	}
+
+	t.Run("matched error rolls back and returns nil", func(t *testing.T) {
+		var callbackExecuted bool
+		err := WithTransaction(softCtx(), func(txCtx context.Context) error {
 			OnSuccess(txCtx, func() {
 				callbackExecuted = true
-				// Read the committed data
-				var foundModel TestModel
-				if err := db.Where("name = ?", "callback-db-test").First(&foundModel).Error; err == nil {
-					callbackDbValue = &foundModel
-				}
 			})
+			model := TestModel{Name: "rollback-if-matched"}
+			if err := Current(txCtx).Create(&model).Error; err != nil {
+				return err
+			}
+			return errSoftFail
+		})
+		if err != nil {
+			t.Fatalf("expected matched error to be swallowed, got: %v", err)
+		}
+		if callbackExecuted {
+			t.Error("callback should not be executed after rollback")
+		}
 
-			return nil
-		}()
+		var count int64
+		db.Model(&TestModel{}).Where("name = ?", "rollback-if-matched").Count(&count)
+		if count != 0 {
+			t.Errorf("expected 0 records after rollback, got %d", count)
+		}
+	})
+
+	t.Run("unmatched error rolls back and propagates", func(t *testing.T) {
+		testErr := errors.New("hard fail")
+		err := WithTransaction(softCtx(), func(txCtx context.Context) error {
+			model := TestModel{Name: "rollback-if-unmatched"}
+			if err := Current(txCtx).Create(&model).Error; err != nil {
+				return err
+			}
+			return testErr
+		})
+		if err != testErr {
+			t.Fatalf("expected test error, got: %v", err)
+		}
+
+		var count int64
+		db.Model(&TestModel{}).Where("name = ?", "rollback-if-unmatched").Count(&count)
+		if count != 0 {
+			t.Errorf("expected 0 records after rollback, got %d", count)
+		}
+	})
 
+	t.Run("nested transactions do not inherit predicate", func(t *testing.T) {
+		var innerErr error
+		err := WithTransaction(softCtx(), func(txCtx context.Context) error {
+			innerErr = WithTransaction(txCtx, func(context.Context) error {
+				return errSoftFail
+			})
+			return nil
+		})
 		if err != nil {
 			t.Fatalf("transaction failed: %v", err)
 		}
+		if innerErr != errSoftFail {
+			t.Errorf("expected nested transaction to return soft fail error, got: %v", innerErr)
+		}
+	})
+	t.Run("context can be reused", func(t *testing.T) {
+		ctx := softCtx()
+		fail := func(context.Context) error { return errSoftFail }
 
-		if !callbackExecuted {
-			t.Error("expected callback to be executed")
+		for i := 0; i < 2; i++ {
+			if err := WithTransaction(ctx, fail); err != nil {
+				t.Fatalf("expected call %d to swallow the error, got: %v", i+1, err)
+			}
 		}
 
-		if callbackDbValue == nil {
-			t.Error("expected callback to read committed data from database")
-		} else if callbackDbValue.Name != "callback-db-test" {
-			t.Errorf("expected callback to read correct data, got name: %s", callbackDbValue.Name)
+		errs := RunEach(ctx, []int{1, 2, 3}, func(context.Context, int) error {
+			return errSoftFail
+		})
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("expected item %d to swallow the error, got: %v", i, err)
+			}
 		}
 	})
+}
 
-	t.Run("OnSuccess with nested transactions", func(t *testing.T) {
-		var outerCallbackExecuted bool
-		var innerCallbackExecuted bool
-		
-		err := WithTransaction(ctx, func(outerCtx context.Context) error {
-			// Register callback in outer transaction
-			OnSuccess(outerCtx, func() {
-				outerCallbackExecuted = true
+func TestPrepare(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+	prepareErr := errors.New("prepare failed")
+
+	t.Run("failing prepare rolls back WithTransaction", func(t *testing.T) {
+		var callbackExecuted bool
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			OnSuccess(txCtx, func() {
+				callbackExecuted = true
+			})
+			Prepare(txCtx, func() error {
+				return prepareErr
 			})
+			model := TestModel{Name: "prepare-fail-tx"}
+			return Current(txCtx).Create(&model).Error
+		})
+		if !errors.Is(err, prepareErr) {
+			t.Fatalf("expected prepare error, got: %v", err)
+		}
+		if callbackExecuted {
+			t.Error("callback should not be executed when prepare fails")
+		}
 
-			// Create model in outer transaction
-			model1 := TestModel{Name: "outer-nested"}
-			if err := Current(outerCtx).Create(&model1).Error; err != nil {
-				return err
-			}
+		var count int64
+		db.Model(&TestModel{}).Where("name = ?", "prepare-fail-tx").Count(&count)
+		if count != 0 {
+			t.Errorf("expected 0 records after failed prepare, got %d", count)
+		}
+	})
 
-			// Start inner transaction
-			return WithTransaction(outerCtx, func(innerCtx context.Context) error {
-				// Register callback in inner transaction
-				OnSuccess(innerCtx, func() {
-					innerCallbackExecuted = true
-				})
+	t.Run("failing prepare rolls back WithDefer", func(t *testing.T) {
+		var prepared []int
+		err := func() (err error) {
+			txCtx, cleanup := WithDefer(ctx)
+			defer cleanup(&err)
 
-				// Create model in inner transaction
-				model2 := TestModel{Name: "inner-nested"}
-				return Current(innerCtx).Create(&model2).Error
+			Prepare(txCtx, func() error {
+				prepared = append(prepared, 1)
+				return prepareErr
 			})
-		})
+			Prepare(txCtx, func() error {
+				prepared = append(prepared, 2)
+				return nil
+			})
+			model := TestModel{Name: "prepare-fail-defer"}
+			return Current(txCtx).Create(&model).Error
+		}()
+		if !errors.Is(err, prepareErr) {
+			t.Fatalf("expected prepare error, got: %v", err)
+		}
+		if len(prepared) != 1 {
+			t.Errorf("expected hooks after the failing one to be skipped, got %v", prepared)
+		}
 
-		if err != nil {
-			t.Fatalf("nested transaction failed: %v", err)
+		var count int64
+		db.Model(&TestModel{}).Where("name = ?", "prepare-fail-defer").Count(&count)
+		if count != 0 {
+			t.Errorf("expected 0 records after failed prepare, got %d", count)
 		}
+	})
 
-		if !outerCallbackExecuted {
-			t.Error("expected outer callback to be executed")
+	t.Run("successful prepare runs before commit", func(t *testing.T) {
+		var order []string
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			OnSuccess(txCtx, func() {
+				order = append(order, "success")
+			})
+			Prepare(txCtx, func() error {
+				order = append(order, "prepare")
+				return nil
+			})
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
 		}
+		if len(order) != 2 || order[0] != "prepare" || order[1] != "success" {
+			t.Errorf("expected prepare before success callback, got %v", order)
+		}
+	})
 
-		if !innerCallbackExecuted {
-			t.Error("expected inner callback to be executed")
+	t.Run("without transaction runs immediately", func(t *testing.T) {
+		if err := Prepare(ctx, func() error { return prepareErr }); err != prepareErr {
+			t.Errorf("expected prepare error to be returned immediately, got: %v", err)
 		}
 	})
+}
 
-	t.Run("OnSuccess stress test", func(t *testing.T) {
-		// Use a separate DB for stress testing to avoid lock contention
-		stressDB := setupTestDB(t)
-		stressCtx := New(context.Background(), stressDB)
-		
-		const numTransactions = 50
-		var successCount int
+func TestDetached(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
 
-		// Run transactions sequentially to avoid SQLite lock contention
-		for i := 0; i < numTransactions; i++ {
-			err := func() (err error) {
-				txCtx, cleanup := WithDefer(stressCtx)
-				defer cleanup(&err)
+	t.Run("strips transaction inside fn", func(t *testing.T) {
+		testErr := errors.New("outer rollback")
+		var detachedCallbackExecuted bool
 
-				OnSuccess(txCtx, func() {
-					successCount++
-				})
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			err := Detached(txCtx, func(detachedCtx context.Context) error {
+				if IsTx(detachedCtx) {
+					t.Error("expected IsTx to return false inside Detached")
+				}
+				if Current(detachedCtx) == Current(txCtx) {
+					t.Error("expected Current to differ from the transaction DB inside Detached")
+				}
 
-				model := TestModel{Name: fmt.Sprintf("stress-test-%d", i)}
-				return Current(txCtx).Create(&model).Error
-			}()
+				OnSuccess(detachedCtx, func() {
+					detachedCallbackExecuted = true
+				})
 
+				model := TestModel{Name: "detached-write"}
+				return Current(detachedCtx).Create(&model).Error
+			})
 			if err != nil {
-				t.Errorf("transaction %d failed: %v", i, err)
+				return err
+			}
+
+			if !IsTx(txCtx) {
+				t.Error("expected transaction context to be unaffected by Detached")
 			}
+			return testErr
+		})
+		if err != testErr {
+			t.Fatalf("expected test error, got: %v", err)
 		}
+		if !detachedCallbackExecuted {
+			t.Error("expected callback in detached scope to execute immediately")
+		}
+
+		var count int64
+		db.Model(&TestModel{}).Where("name = ?", "detached-write").Count(&count)
+		if count != 1 {
+			t.Errorf("expected detached write to persist after outer rollback, got %d records", count)
+		}
+	})
+
+	t.Run("context without DB", func(t *testing.T) {
+		err := Detached(context.Background(), func(context.Context) error {
+			t.Error("fn should not be called without a DB")
+			return nil
+		})
+		if err != gorm.ErrInvalidTransaction {
+			t.Errorf("expected ErrInvalidTransaction, got: %v", err)
+		}
+	})
+}
+
+func TestCurrentConcurrentWithCallbacks(t *testing.T) {
+	db := setupTestDB(t)
+	txCtx := Begin(New(context.Background(), db))
+	defer Rollback(txCtx)
+
+	txDB := Current(txCtx)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if Current(txCtx) != txDB {
+					t.Error("expected Current to return the transaction DB")
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				OnSuccess(txCtx, func() {})
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkCurrent(b *testing.B) {
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		b.Fatalf("failed to connect database: %v", err)
+	}
+	ctx := New(context.Background(), db)
+
+	b.Run("serial", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			Current(ctx)
+		}
+	})
+
+	b.Run("parallel", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				Current(ctx)
+			}
+		})
+	})
+}
+
+func TestCommitIf(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	tests := []struct {
+		name          string
+		valid         bool
+		expectedCount int64
+	}{
+		{name: "predicate true commits", valid: true, expectedCount: 1},
+		{name: "predicate false rolls back", valid: false, expectedCount: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txCtx := Begin(ctx)
+
+			var callbackExecuted bool
+			OnSuccess(txCtx, func() {
+				callbackExecuted = true
+			})
+
+			model := TestModel{Name: "commit-if-" + tt.name}
+			if err := Current(txCtx).Create(&model).Error; err != nil {
+				t.Fatalf("failed to create model: %v", err)
+			}
+
+			if err := CommitIf(txCtx, func() bool { return tt.valid }); err != nil {
+				t.Fatalf("CommitIf failed: %v", err)
+			}
+
+			if callbackExecuted != tt.valid {
+				t.Errorf("expected callback executed to be %v, got %v", tt.valid, callbackExecuted)
+			}
+
+			var count int64
+			db.Model(&TestModel{}).Where("name = ?", model.Name).Count(&count)
+			if count != tt.expectedCount {
+				t.Errorf("expected %d records, got %d", tt.expectedCount, count)
+			}
+		})
+	}
+}
+
+func TestCommitAndContinue(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	count := func(name string) int64 {
+		var n int64
+		db.Model(&TestModel{}).Where("name = ?", name).Count(&n)
+		return n
+	}
+
+	t.Run("checkpoint survives later rollback", func(t *testing.T) {
+		var callbackRan bool
+		txCtx := Begin(ctx)
+		if err := Current(txCtx).Create(&TestModel{Name: "before"}).Error; err != nil {
+			t.Fatalf("create failed: %v", err)
+		}
+		OnSuccess(txCtx, func() { callbackRan = true })
+
+		nextCtx, err := CommitAndContinue(txCtx)
+		if err != nil {
+			t.Fatalf("checkpoint failed: %v", err)
+		}
+		if !callbackRan {
+			t.Error("expected callbacks to run at the checkpoint")
+		}
+		if !IsTx(nextCtx) || !OwnsTransaction(nextCtx) || SameTransaction(txCtx, nextCtx) {
+			t.Fatal("expected a fresh transaction")
+		}
+
+		if err := Current(nextCtx).Create(&TestModel{Name: "after"}).Error; err != nil {
+			t.Fatalf("create failed: %v", err)
+		}
+		if err := Rollback(nextCtx); err != nil {
+			t.Fatalf("rollback failed: %v", err)
+		}
+
+		if count("before") != 1 {
+			t.Error("expected the row before the checkpoint to persist")
+		}
+		if count("after") != 0 {
+			t.Error("expected the row after the checkpoint to be rolled back")
+		}
+	})
+
+	t.Run("savepoint", func(t *testing.T) {
+		err := WithTransaction(ctx, func(outerCtx context.Context) error {
+			spCtx := Begin(outerCtx)
+			nextCtx, err := CommitAndContinue(spCtx)
+			if err != nil {
+				return err
+			}
+			if !IsTx(nextCtx) || fromContext(nextCtx).savepoint == "" {
+				t.Error("expected a new savepoint")
+			}
+			if fromContext(nextCtx).parent != fromContext(outerCtx) {
+				t.Error("expected the new savepoint in the enclosing transaction")
+			}
+			return Commit(nextCtx)
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+	})
+
+	t.Run("outside a transaction", func(t *testing.T) {
+		if _, err := CommitAndContinue(ctx); err != gorm.ErrInvalidTransaction {
+			t.Errorf("expected ErrInvalidTransaction, got %v", err)
+		}
+	})
+}
+
+func TestWithoutCallbacks(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	var fired []string
+	err := WithTransaction(ctx, func(txCtx context.Context) error {
+		OnSuccess(txCtx, func() { fired = append(fired, "outer") })
+
+		quiet := WithoutCallbacks(txCtx)
+		OnSuccess(quiet, func() { fired = append(fired, "quiet") })
+		err := WithTransaction(quiet, func(nestedCtx context.Context) error {
+			OnSuccess(nestedCtx, func() { fired = append(fired, "nested") })
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		OnSuccess(txCtx, func() { fired = append(fired, "after") })
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+	if len(fired) != 2 || fired[0] != "outer" || fired[1] != "after" {
+		t.Errorf("expected only the outer callbacks to fire, got %v", fired)
+	}
+
+	fired = nil
+	OnSuccess(WithoutCallbacks(ctx), func() { fired = append(fired, "immediate") })
+	if len(fired) != 0 {
+		t.Errorf("expected the callback to be dropped outside a transaction, got %v", fired)
+	}
+	t.Run("compensations are kept", func(t *testing.T) {
+		sagaCtx, saga := NewSaga(ctx)
+		ran := false
+		err := saga.Step(sagaCtx, func(stepCtx context.Context) error {
+			AddCompensation(WithoutCallbacks(stepCtx), func() error {
+				ran = true
+				return nil
+			})
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("step failed: %v", err)
+		}
+
+		if err := saga.Compensate(); err != nil {
+			t.Fatalf("compensation failed: %v", err)
+		}
+		if !ran {
+			t.Error("expected the compensation to run")
+		}
+	})
+
+	t.Run("OnSuccessTx is kept", func(t *testing.T) {
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			OnSuccessTx(WithoutCallbacks(txCtx), func(ctx context.Context) error {
+				return Current(ctx).Create(&TestModel{Name: "without-callbacks-tx"}).Error
+			})
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		var count int64
+		db.Model(&TestModel{}).Where("name = ?", "without-callbacks-tx").Count(&count)
+		if count != 1 {
+			t.Errorf("expected the OnSuccessTx work to run, got %d records", count)
+		}
+	})
+}
+
+func TestWithSharedCallbacks(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	t.Run("nested callbacks fire after outer commit", func(t *testing.T) {
+		var order []string
+		err := WithTransaction(ctx, func(outerCtx context.Context) error {
+			OnSuccess(outerCtx, func() {
+				order = append(order, "outer")
+			})
+
+			err := WithTransaction(WithSharedCallbacks(outerCtx), func(innerCtx context.Context) error {
+				OnSuccess(innerCtx, func() {
+					order = append(order, "inner")
+				})
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			if len(order) != 0 {
+				t.Errorf("expected no callbacks after savepoint release, got %v", order)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		if len(order) != 2 || order[0] != "outer" || order[1] != "inner" {
+			t.Errorf("expected [outer inner] after outer commit, got %v", order)
+		}
+	})
+
+	t.Run("nested WithDefer callbacks fire after outer commit", func(t *testing.T) {
+		var innerFired bool
+		err := func() (err error) {
+			outerCtx, cleanup := WithDefer(ctx)
+			defer cleanup(&err)
+
+			func() {
+				var innerErr error
+				innerCtx, innerCleanup := WithDefer(WithSharedCallbacks(outerCtx))
+				defer innerCleanup(&innerErr)
+
+				OnSuccess(innerCtx, func() {
+					innerFired = true
+				})
+			}()
+
+			if innerFired {
+				t.Error("expected inner callback to wait for outer commit")
+			}
+			return nil
+		}()
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if !innerFired {
+			t.Error("expected inner callback to fire after outer commit")
+		}
+	})
+
+	t.Run("rolled back nested callbacks are discarded", func(t *testing.T) {
+		var innerFired bool
+		err := WithTransaction(ctx, func(outerCtx context.Context) error {
+			WithTransaction(WithSharedCallbacks(outerCtx), func(innerCtx context.Context) error {
+				OnSuccess(innerCtx, func() {
+					innerFired = true
+				})
+				return errors.New("inner failure")
+			})
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if innerFired {
+			t.Error("callback of rolled back nested transaction should not fire")
+		}
+	})
+
+	t.Run("outer rollback discards shared callbacks", func(t *testing.T) {
+		var innerFired bool
+		WithTransaction(ctx, func(outerCtx context.Context) error {
+			WithTransaction(WithSharedCallbacks(outerCtx), func(innerCtx context.Context) error {
+				OnSuccess(innerCtx, func() {
+					innerFired = true
+				})
+				return nil
+			})
+			return errors.New("outer failure")
+		})
+		if innerFired {
+			t.Error("shared callback should not fire when outer transaction rolls back")
+		}
+	})
+}
+
+func TestWithValues(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := WithValues(New(context.Background(), db), map[string]any{"tenant": "acme", "actor": "alice"})
+
+	var tenant, actor, nestedActor any
+	err := WithTransaction(ctx, func(txCtx context.Context) error {
+		OnSuccess(txCtx, func() {
+			tenant = Value(txCtx, "tenant")
+			actor = Value(txCtx, "actor")
+		})
+
+		nestedCtx := WithValues(txCtx, map[string]any{"actor": "bob"})
+		return WithTransaction(nestedCtx, func(innerCtx context.Context) error {
+			nestedActor = Value(innerCtx, "actor")
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+
+	if tenant != "acme" || actor != "alice" {
+		t.Errorf("expected tenant acme and actor alice in callback, got %v and %v", tenant, actor)
+	}
+	if nestedActor != "bob" {
+		t.Errorf("expected nested value to override actor, got %v", nestedActor)
+	}
+	if Value(ctx, "actor") != "alice" {
+		t.Error("expected nested WithValues not to affect the outer context")
+	}
+	if Value(ctx, "missing") != nil || Value(nil, "tenant") != nil {
+		t.Error("expected nil for missing values")
+	}
+}
+
+var errSavepointFailed = errors.New("savepoint failed")
+
+// failingSavepointDialector fails every savepoint it is asked to create
+type failingSavepointDialector struct {
+	gorm.Dialector
+}
+
+func (failingSavepointDialector) SavePoint(*gorm.DB, string) error {
+	return errSavepointFailed
+}
+
+func (d failingSavepointDialector) RollbackTo(tx *gorm.DB, name string) error {
+	return d.Dialector.(gorm.SavePointerDialectorInterface).RollbackTo(tx, name)
+}
+
+func TestBeginError(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	t.Run("successful begin", func(t *testing.T) {
+		txCtx := Begin(ctx)
+		defer Rollback(txCtx)
+
+		if err := BeginError(txCtx); err != nil {
+			t.Errorf("expected no begin error, got: %v", err)
+		}
+	})
+
+	t.Run("no transaction", func(t *testing.T) {
+		if err := BeginError(ctx); err != nil {
+			t.Errorf("expected no begin error, got: %v", err)
+		}
+		if err := BeginError(context.Background()); err != nil {
+			t.Errorf("expected no begin error, got: %v", err)
+		}
+	})
+
+	t.Run("begin on closed DB", func(t *testing.T) {
+		closedDB := setupTestDB(t)
+		sqlDB, err := closedDB.DB()
+		if err != nil {
+			t.Fatalf("failed to get sql.DB: %v", err)
+		}
+		sqlDB.Close()
+
+		txCtx := Begin(New(context.Background(), closedDB))
+		if IsTx(txCtx) {
+			t.Error("expected IsTx to return false when begin failed")
+		}
+		if BeginError(txCtx) == nil {
+			t.Error("expected begin error for closed DB")
+		}
+	})
+
+	t.Run("savepoint failure", func(t *testing.T) {
+		db := setupTestDB(t).Session(&gorm.Session{})
+		db.Dialector = failingSavepointDialector{db.Dialector}
+		ctx := New(context.Background(), db)
+
+		outerCtx := Begin(ctx)
+		spCtx := Begin(outerCtx)
+		if !errors.Is(BeginError(spCtx), errSavepointFailed) {
+			t.Fatalf("expected the savepoint error, got %v", BeginError(spCtx))
+		}
+		if IsTx(spCtx) {
+			t.Error("expected IsTx to return false when the savepoint failed")
+		}
+		if err := Current(spCtx).Create(&TestModel{Name: "failed-savepoint"}).Error; !errors.Is(err, errSavepointFailed) {
+			t.Errorf("expected writes to fail with the savepoint error, got %v", err)
+		}
+		if err := Commit(outerCtx); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+
+		var count int64
+		db.Model(&TestModel{}).Where("name = ?", "failed-savepoint").Count(&count)
+		if count != 0 {
+			t.Errorf("expected the write not to reach the enclosing transaction, got %d rows", count)
+		}
+	})
+
+	t.Run("WithDefer reports begin error", func(t *testing.T) {
+		closedDB := setupTestDB(t)
+		sqlDB, _ := closedDB.DB()
+		sqlDB.Close()
+
+		closedCtx := New(context.Background(), closedDB)
+		err := func() (err error) {
+			_, cleanup := WithDefer(closedCtx)
+			defer cleanup(&err)
+			return nil
+		}()
+		if err == nil {
+			t.Error("expected WithDefer cleanup to report the begin error")
+		}
+	})
+}
+
+func TestTry(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+	tryErr := errors.New("optional work failed")
+
+	t.Run("failure keeps enclosing transaction", func(t *testing.T) {
+		var gotErr error
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			before := TestModel{Name: "try-before"}
+			if err := Current(txCtx).Create(&before).Error; err != nil {
+				return err
+			}
+
+			gotErr = Try(txCtx, func(tryCtx context.Context) error {
+				optional := TestModel{Name: "try-optional"}
+				if err := Current(tryCtx).Create(&optional).Error; err != nil {
+					return err
+				}
+				return tryErr
+			})
+
+			after := TestModel{Name: "try-after"}
+			return Current(txCtx).Create(&after).Error
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if gotErr != tryErr {
+			t.Errorf("expected Try to return its error, got: %v", gotErr)
+		}
+
+		for name, expected := range map[string]int64{"try-before": 1, "try-optional": 0, "try-after": 1} {
+			var count int64
+			db.Model(&TestModel{}).Where("name = ?", name).Count(&count)
+			if count != expected {
+				t.Errorf("expected %d records named %s, got %d", expected, name, count)
+			}
+		}
+	})
+
+	t.Run("outside transaction runs fn", func(t *testing.T) {
+		var ran bool
+		err := Try(ctx, func(tryCtx context.Context) error {
+			ran = true
+			if IsTx(tryCtx) {
+				t.Error("expected no transaction outside an enclosing transaction")
+			}
+			return tryErr
+		})
+		if !ran || err != tryErr {
+			t.Errorf("expected fn to run and return its error, got ran=%v err=%v", ran, err)
+		}
+	})
+}
+
+func TestBeginCancelable(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	t.Run("cancel rolls back", func(t *testing.T) {
+		txCtx, cancel := BeginCancelable(ctx)
+		model := TestModel{Name: "cancelable-rollback"}
+		if err := Current(txCtx).Create(&model).Error; err != nil {
+			t.Fatalf("failed to create model: %v", err)
+		}
+
+		cancel()
+
+		if txCtx.Err() != context.Canceled {
+			t.Errorf("expected context to be canceled, got: %v", txCtx.Err())
+		}
+
+		var count int64
+		db.Model(&TestModel{}).Where("name = ?", "cancelable-rollback").Count(&count)
+		if count != 0 {
+			t.Errorf("expected 0 records after cancel, got %d", count)
+		}
+
+		// Cancel is safe to call again
+		cancel()
+	})
+
+	t.Run("cancel after commit keeps data", func(t *testing.T) {
+		txCtx, cancel := BeginCancelable(ctx)
+		defer cancel()
+
+		model := TestModel{Name: "cancelable-commit"}
+		if err := Current(txCtx).Create(&model).Error; err != nil {
+			t.Fatalf("failed to create model: %v", err)
+		}
+		if err := Commit(txCtx); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+
+		cancel()
+
+		var count int64
+		db.Model(&TestModel{}).Where("name = ?", "cancelable-commit").Count(&count)
+		if count != 1 {
+			t.Errorf("expected 1 record after commit, got %d", count)
+		}
+	})
+
+	t.Run("cancel while a query is in flight", func(t *testing.T) {
+		db, pool := setupPoolTestDB(t)
+		txCtx, cancel := BeginCancelable(New(context.Background(), db))
+
+		started := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			close(started)
+			for i := 0; ; i++ {
+				if err := Current(txCtx).Create(&TestModel{Name: fmt.Sprintf("in-flight-%d", i)}).Error; err != nil {
+					done <- err
+					return
+				}
+			}
+		}()
+
+		<-started
+		time.Sleep(5 * time.Millisecond)
+		cancel()
+
+		if err := <-done; err == nil {
+			t.Error("expected the in-flight query to fail after cancel")
+		}
+		if err := Commit(txCtx); err == nil {
+			t.Error("expected commit after cancel to fail")
+		}
+		if n := atomic.LoadInt64(&pool.rolledBack); n != 1 {
+			t.Errorf("expected 1 rollback, got %d", n)
+		}
+		if n := atomic.LoadInt64(&pool.committed); n != 0 {
+			t.Errorf("expected no commit, got %d", n)
+		}
+
+		var count int64
+		db.Model(&TestModel{}).Count(&count)
+		if count != 0 {
+			t.Errorf("expected 0 records after cancel, got %d", count)
+		}
+	})
+
+	t.Run("cancel a savepoint", func(t *testing.T) {
+		err := WithTransaction(ctx, func(outerCtx context.Context) error {
+			spCtx, cancel := BeginCancelable(outerCtx)
+			if err := Current(spCtx).Create(&TestModel{Name: "cancelable-savepoint"}).Error; err != nil {
+				return err
+			}
+
+			cancel()
+			if err := Commit(spCtx); err != context.Canceled {
+				t.Errorf("expected context.Canceled committing the savepoint, got %v", err)
+			}
+			return Current(outerCtx).Create(&TestModel{Name: "cancelable-outer"}).Error
+		})
+		if err != nil {
+			t.Fatalf("expected enclosing transaction to commit, got %v", err)
+		}
+
+		var names []string
+		db.Model(&TestModel{}).Where("name IN ?", []string{"cancelable-savepoint", "cancelable-outer"}).Pluck("name", &names)
+		if len(names) != 1 || names[0] != "cancelable-outer" {
+			t.Errorf("expected only the enclosing transaction's row, got %v", names)
+		}
+	})
+
+	t.Run("context without DB", func(t *testing.T) {
+		txCtx, cancel := BeginCancelable(context.Background())
+		defer cancel()
+
+		if IsTx(txCtx) {
+			t.Error("expected no transaction without a DB")
+		}
+	})
+}
+
+func TestWithTransactionCallbacksAfterCommit(t *testing.T) {
+	t.Run("commit failure skips callbacks", func(t *testing.T) {
+		failingDB, _ := setupPoolTestDB(t, failCommits)
+		ctx := New(context.Background(), failingDB)
+
+		called := false
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			OnSuccess(txCtx, func() { called = true })
+			return Current(txCtx).Create(&TestModel{Name: "test"}).Error
+		})
+		if !errors.Is(err, errCommitFailed) {
+			t.Fatalf("expected commit error, got %v", err)
+		}
+		if called {
+			t.Error("expected callback not to run when the commit fails")
+		}
+	})
+
+	t.Run("callbacks run after commit", func(t *testing.T) {
+		db := setupTestDB(t)
+		ctx := New(context.Background(), db)
+
+		var committed int64 = -1
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			OnSuccess(txCtx, func() {
+				// Visible from outside the transaction only once committed
+				db.Model(&TestModel{}).Count(&committed)
+			})
+			return Current(txCtx).Create(&TestModel{Name: "test"}).Error
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if committed != 1 {
+			t.Errorf("expected callback to observe the committed row, got count %d", committed)
+		}
+	})
+}
+
+func TestSuspend(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	t.Run("writes in fn auto-commit", func(t *testing.T) {
+		testErr := errors.New("outer rollback")
+
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			// SQLite's shared cache locks the table once the transaction
+			// writes, so the suspended write comes first
+			err := Suspend(txCtx, func(suspendedCtx context.Context) error {
+				if IsTx(suspendedCtx) {
+					t.Error("expected IsTx to return false while suspended")
+				}
+				return Current(suspendedCtx).Create(&TestModel{Name: "suspend-auto"}).Error
+			})
+			if err != nil {
+				return err
+			}
+
+			if !IsTx(txCtx) {
+				t.Error("expected transaction to resume after Suspend")
+			}
+			if err := Current(txCtx).Create(&TestModel{Name: "suspend-tx"}).Error; err != nil {
+				return err
+			}
+			return testErr
+		})
+		if err != testErr {
+			t.Fatalf("expected test error, got: %v", err)
+		}
+
+		var count int64
+		db.Model(&TestModel{}).Where("name = ?", "suspend-auto").Count(&count)
+		if count != 1 {
+			t.Errorf("expected suspended write to persist, got %d records", count)
+		}
+		db.Model(&TestModel{}).Where("name = ?", "suspend-tx").Count(&count)
+		if count != 0 {
+			t.Errorf("expected transactional writes to roll back, got %d records", count)
+		}
+	})
+
+	t.Run("outside a transaction", func(t *testing.T) {
+		called := false
+		err := Suspend(ctx, func(context.Context) error {
+			called = true
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !called {
+			t.Error("expected fn to run without a transaction")
+		}
+
+		// Like Detached, Suspend needs a database
+		if err := Suspend(context.Background(), func(context.Context) error { return nil }); err != gorm.ErrInvalidTransaction {
+			t.Errorf("expected ErrInvalidTransaction without a database, got %v", err)
+		}
+	})
+}
+
+func TestNilFunc(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	entryPoints := map[string]func(context.Context, func(context.Context) error) error{
+		"WithTransaction": func(ctx context.Context, fn func(context.Context) error) error {
+			return WithTransaction(ctx, fn)
+		},
+		"Detached": Detached,
+		"Try":      Try,
+		"Suspend":  Suspend,
+	}
+
+	for name, run := range entryPoints {
+		t.Run(name, func(t *testing.T) {
+			if err := run(ctx, nil); !errors.Is(err, ErrNilFunc) {
+				t.Errorf("expected ErrNilFunc, got %v", err)
+			}
+
+			err := WithTransaction(ctx, func(txCtx context.Context) error {
+				return run(txCtx, nil)
+			})
+			if !errors.Is(err, ErrNilFunc) {
+				t.Errorf("expected ErrNilFunc inside a transaction, got %v", err)
+			}
+		})
+	}
+
+	t.Run("WithDefer nil error pointer", func(t *testing.T) {
+		func() {
+			txCtx, cleanup := WithDefer(ctx)
+			defer cleanup(nil)
+			Current(txCtx).Create(&TestModel{Name: "nil-pointer"})
+		}()
+
+		var count int64
+		db.Model(&TestModel{}).Where("name = ?", "nil-pointer").Count(&count)
+		if count != 1 {
+			t.Errorf("expected cleanup(nil) to commit, got %d records", count)
+		}
+	})
+}
+
+func TestWithFlatNesting(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := WithFlatNesting(New(context.Background(), db))
+
+	t.Run("no savepoints", func(t *testing.T) {
+		logCtx := WithQueryLog(ctx)
+		var called bool
+
+		err := WithTransaction(logCtx, func(txCtx context.Context) error {
+			return WithTransaction(txCtx, func(innerCtx context.Context) error {
+				if Current(innerCtx) != Current(txCtx) {
+					t.Error("expected nested call to reuse the transaction DB")
+				}
+				OnSuccess(innerCtx, func() { called = true })
+				return Current(innerCtx).Create(&TestModel{Name: "flat"}).Error
+			})
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if !called {
+			t.Error("expected nested callback to run after the commit")
+		}
+
+		for _, stmt := range QueryLog(logCtx) {
+			if strings.Contains(stmt, "SAVEPOINT") {
+				t.Errorf("expected no savepoints, got %q", stmt)
+			}
+		}
+	})
+
+	t.Run("inner error rolls back everything", func(t *testing.T) {
+		testErr := errors.New("inner failure")
+
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			if err := Current(txCtx).Create(&TestModel{Name: "flat-outer"}).Error; err != nil {
+				return err
+			}
+			return WithTransaction(txCtx, func(innerCtx context.Context) error {
+				Current(innerCtx).Create(&TestModel{Name: "flat-inner"})
+				return testErr
+			})
+		})
+		if err != testErr {
+			t.Fatalf("expected inner error, got %v", err)
+		}
+
+		var count int64
+		db.Model(&TestModel{}).Where("name IN ?", []string{"flat-outer", "flat-inner"}).Count(&count)
+		if count != 0 {
+			t.Errorf("expected whole transaction to roll back, got %d records", count)
+		}
+	})
+
+	t.Run("failed Try still rolls back its savepoint", func(t *testing.T) {
+		testErr := errors.New("optional work failed")
+
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			tryErr := Try(txCtx, func(tryCtx context.Context) error {
+				if err := Current(tryCtx).Create(&TestModel{Name: "flat-tried"}).Error; err != nil {
+					return err
+				}
+				return testErr
+			})
+			if tryErr != testErr {
+				t.Errorf("expected Try to return its error, got %v", tryErr)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		var count int64
+		db.Model(&TestModel{}).Where("name = ?", "flat-tried").Count(&count)
+		if count != 0 {
+			t.Errorf("expected the failed Try to leave no rows, got %d", count)
+		}
+	})
+
+	t.Run("failed RunEach item rolls back on its own", func(t *testing.T) {
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			errs := RunEach(txCtx, []string{"flat-each-ok", "flat-each-bad"}, func(itemCtx context.Context, name string) error {
+				if err := Current(itemCtx).Create(&TestModel{Name: name}).Error; err != nil {
+					return err
+				}
+				if name == "flat-each-bad" {
+					return errors.New("bad item")
+				}
+				return nil
+			})
+			if errs[0] != nil || errs[1] == nil {
+				t.Errorf("unexpected item errors: %v", errs)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		var ok, bad int64
+		db.Model(&TestModel{}).Where("name = ?", "flat-each-ok").Count(&ok)
+		db.Model(&TestModel{}).Where("name = ?", "flat-each-bad").Count(&bad)
+		if ok != 1 || bad != 0 {
+			t.Errorf("expected only the good item to persist, got %d good and %d bad rows", ok, bad)
+		}
+	})
+}
+
+func TestCommitAfterCancel(t *testing.T) {
+	db := setupTestDB(t)
+	ctx, cancel := context.WithCancel(New(context.Background(), db))
+	defer cancel()
+
+	txCtx := Begin(ctx)
+	if err := Current(txCtx).Create(&TestModel{Name: "cancelled"}).Error; err != nil {
+		t.Fatalf("failed to create record: %v", err)
+	}
+
+	var called bool
+	OnSuccess(txCtx, func() { called = true })
+
+	cancel()
+	if err := Commit(txCtx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if called {
+		t.Error("expected callback not to run")
+	}
+
+	var count int64
+	db.Model(&TestModel{}).Where("name = ?", "cancelled").Count(&count)
+	if count != 0 {
+		t.Errorf("expected transaction to be rolled back, got %d records", count)
+	}
+}
+
+func TestSnapshotCallbacks(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	var fired []string
+	err := WithTransaction(ctx, func(txCtx context.Context) error {
+		OnSuccess(txCtx, func() { fired = append(fired, "before") })
+
+		restore := SnapshotCallbacks(txCtx)
+		OnSuccess(txCtx, func() { fired = append(fired, "discarded-1") })
+		OnSuccess(txCtx, func() { fired = append(fired, "discarded-2") })
+		restore()
+
+		OnSuccess(txCtx, func() { fired = append(fired, "after") })
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+
+	if len(fired) != 2 || fired[0] != "before" || fired[1] != "after" {
+		t.Errorf("expected only callbacks outside the discarded branch to fire, got %v", fired)
+	}
+
+	t.Run("outside a transaction", func(t *testing.T) {
+		restore := SnapshotCallbacks(ctx)
+		restore()
+	})
+}
+
+func TestWithTransactionIfMany(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	write := func(names ...string) func(context.Context, func(int) context.Context) error {
+		return func(ctx context.Context, writes func(int) context.Context) error {
+			ctx = writes(len(names))
+			for _, name := range names {
+				if err := Current(ctx).Create(&TestModel{Name: name}).Error; err != nil {
+					return err
+				}
+			}
+			if IsTx(ctx) != (len(names) >= 2) {
+				t.Errorf("unexpected IsTx %v for %d writes", IsTx(ctx), len(names))
+			}
+			return nil
+		}
+	}
+
+	if err := WithTransactionIfMany(ctx, 2, write("if-many-single")); err != nil {
+		t.Fatalf("single write failed: %v", err)
+	}
+	if err := WithTransactionIfMany(ctx, 2, write("if-many-a", "if-many-b")); err != nil {
+		t.Fatalf("multiple writes failed: %v", err)
+	}
+
+	var count int64
+	db.Model(&TestModel{}).Where("name LIKE ?", "if-many-%").Count(&count)
+	if count != 3 {
+		t.Errorf("expected 3 records, got %d", count)
+	}
+
+	t.Run("rolls back on error", func(t *testing.T) {
+		testErr := errors.New("second write failed")
+		var called bool
+
+		err := WithTransactionIfMany(ctx, 2, func(ctx context.Context, writes func(int) context.Context) error {
+			ctx = writes(2)
+			OnSuccess(ctx, func() { called = true })
+			Current(ctx).Create(&TestModel{Name: "if-many-rollback"})
+			return testErr
+		})
+		if err != testErr {
+			t.Fatalf("expected test error, got %v", err)
+		}
+		if called {
+			t.Error("expected callback not to run after rollback")
+		}
+
+		db.Model(&TestModel{}).Where("name = ?", "if-many-rollback").Count(&count)
+		if count != 0 {
+			t.Errorf("expected write to be rolled back, got %d records", count)
+		}
+	})
+	t.Run("panic is returned as an error", func(t *testing.T) {
+		for _, n := range []int{2, 1} {
+			err := WithTransactionIfMany(ctx, 2, func(ctx context.Context, writes func(int) context.Context) error {
+				ctx = writes(n)
+				Current(ctx).Create(&TestModel{Name: fmt.Sprintf("if-many-panic-%d", n)})
+				panic("boom")
+			})
+
+			var stxErr *STXError
+			if !errors.As(err, &stxErr) || stxErr.Err.Error() != "boom" {
+				t.Errorf("expected the panic as an error with %d writes, got %v", n, err)
+			}
+		}
+
+		db.Model(&TestModel{}).Where("name = ?", "if-many-panic-2").Count(&count)
+		if count != 0 {
+			t.Errorf("expected the transaction to be rolled back, got %d records", count)
+		}
+	})
+}
+
+func TestWithDeferAbort(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	var called bool
+	err := func() (err error) {
+		txCtx, cleanup, abort := WithDeferAbort(ctx)
+		defer cleanup(&err)
+
+		OnSuccess(txCtx, func() { called = true })
+		if err := Current(txCtx).Create(&TestModel{Name: "aborted"}).Error; err != nil {
+			return err
+		}
+
+		abort()
+		return nil
+	}()
+	if err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	if called {
+		t.Error("expected callback not to run after abort")
+	}
+
+	var count int64
+	db.Model(&TestModel{}).Where("name = ?", "aborted").Count(&count)
+	if count != 0 {
+		t.Errorf("expected transaction to be rolled back, got %d records", count)
+	}
+
+	t.Run("commits without abort", func(t *testing.T) {
+		err := func() (err error) {
+			txCtx, cleanup, _ := WithDeferAbort(ctx)
+			defer cleanup(&err)
+			return Current(txCtx).Create(&TestModel{Name: "not-aborted"}).Error
+		}()
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		db.Model(&TestModel{}).Where("name = ?", "not-aborted").Count(&count)
+		if count != 1 {
+			t.Errorf("expected transaction to commit, got %d records", count)
+		}
+	})
+}
+
+func TestSameTransaction(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	txCtx := Begin(ctx)
+	defer Rollback(txCtx)
+
+	derived, cancel := context.WithTimeout(txCtx, time.Minute)
+	defer cancel()
+	if !SameTransaction(txCtx, derived) {
+		t.Error("expected derived context to share the transaction")
+	}
+
+	if SameTransaction(txCtx, New(context.Background(), db)) {
+		t.Error("expected fresh context not to share the transaction")
+	}
+	if SameTransaction(txCtx, ctx) {
+		t.Error("expected parent context not to share the transaction")
+	}
+	if SameTransaction(context.Background(), context.Background()) {
+		t.Error("expected contexts without stx state not to match")
+	}
+}
+
+func TestJoinDefer(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := WithQueryLog(New(context.Background(), db))
+
+	helper := func(ctx context.Context, name string) (err error) {
+		txCtx, cleanup := JoinDefer(ctx)
+		defer cleanup(&err)
+		return Current(txCtx).Create(&TestModel{Name: name}).Error
+	}
+
+	testErr := errors.New("outer rollback")
+	err := func() (err error) {
+		txCtx, cleanup := WithDefer(ctx)
+		defer cleanup(&err)
+
+		joined, joinCleanup := JoinDefer(txCtx)
+		joinCleanup(nil)
+		if !SameTransaction(txCtx, joined) {
+			t.Error("expected JoinDefer to return the enclosing transaction")
+		}
+
+		if err := helper(txCtx, "join-defer"); err != nil {
+			return err
+		}
+		// The helper's cleanup must not have committed
+		return testErr
+	}()
+	if err != testErr {
+		t.Fatalf("expected test error, got %v", err)
+	}
+
+	var count int64
+	db.Model(&TestModel{}).Where("name = ?", "join-defer").Count(&count)
+	if count != 0 {
+		t.Errorf("expected joined work to roll back with the outer transaction, got %d records", count)
+	}
+	for _, stmt := range QueryLog(ctx) {
+		if strings.Contains(stmt, "SAVEPOINT") {
+			t.Errorf("expected no savepoints, got %q", stmt)
+		}
+	}
+
+	t.Run("starts a transaction on its own", func(t *testing.T) {
+		if err := helper(New(context.Background(), db), "join-defer-alone"); err != nil {
+			t.Fatalf("helper failed: %v", err)
+		}
+		db.Model(&TestModel{}).Where("name = ?", "join-defer-alone").Count(&count)
+		if count != 1 {
+			t.Errorf("expected standalone call to commit, got %d records", count)
+		}
+	})
+}
+
+func TestDriverName(t *testing.T) {
+	ctx := New(context.Background(), setupTestDB(t))
+
+	if name := DriverName(ctx); name != "sqlite" {
+		t.Errorf("expected sqlite, got %q", name)
+	}
+
+	err := WithTransaction(ctx, func(txCtx context.Context) error {
+		if name := DriverName(txCtx); name != "sqlite" {
+			t.Errorf("expected sqlite within a transaction, got %q", name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+
+	if name := DriverName(context.Background()); name != "" {
+		t.Errorf("expected empty name without a database, got %q", name)
+	}
+}
+
+// plainConnPool hides the Begin methods of the pool it wraps, which is how
+// a dialector without transaction support looks to GORM
+type plainConnPool struct {
+	gorm.ConnPool
+}
+
+func TestSupportsTransactions(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	if !SupportsTransactions(ctx) {
+		t.Error("expected SQLite to support transactions")
+	}
+
+	err := WithTransaction(ctx, func(txCtx context.Context) error {
+		if !SupportsTransactions(txCtx) {
+			t.Error("expected support within a transaction")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+
+	unsupported := db.Session(&gorm.Session{})
+	unsupported.Statement.ConnPool = plainConnPool{db.ConnPool}
+	unsupportedCtx := New(context.Background(), unsupported)
+	if SupportsTransactions(unsupportedCtx) {
+		t.Error("expected no support without a beginner connection pool")
+	}
+	if err := WithTransaction(unsupportedCtx, func(context.Context) error { return nil }); err == nil {
+		t.Error("expected WithTransaction to fail on the unsupported pool")
+	}
+
+	if SupportsTransactions(context.Background()) {
+		t.Error("expected no support without a database")
+	}
+}
+
+func TestPanicHandlingConsistency(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	entryPoints := map[string]func(ctx context.Context, fn func(context.Context) error) error{
+		"WithTransaction": func(ctx context.Context, fn func(context.Context) error) error {
+			return WithTransaction(ctx, fn)
+		},
+		"WithDefer": func(ctx context.Context, fn func(context.Context) error) (err error) {
+			txCtx, cleanup := WithDefer(ctx)
+			defer cleanup(&err)
+			return fn(txCtx)
+		},
+	}
+
+	domainErr := &testDomainError{Code: 7}
+	panics := map[string]struct {
+		value   any
+		wantMsg string
+	}{
+		"string": {"boom", "recovered from panic: boom"},
+		"error":  {domainErr, domainErr.Error()},
+	}
+
+	for entryName, run := range entryPoints {
+		for panicName, p := range panics {
+			t.Run(entryName+"/"+panicName, func(t *testing.T) {
+				name := "panic-" + entryName + "-" + panicName
+				var called bool
+
+				err := run(ctx, func(txCtx context.Context) error {
+					OnSuccess(txCtx, func() { called = true })
+					Current(txCtx).Create(&TestModel{Name: name})
+					panic(p.value)
+				})
+
+				if err == nil || err.Error() != p.wantMsg {
+					t.Errorf("expected error %q, got %v", p.wantMsg, err)
+				}
+				if p.value == domainErr && err != domainErr {
+					t.Errorf("expected panic error to be returned as-is, got %v", err)
+				}
+				if called {
+					t.Error("expected callback not to run after a panic")
+				}
+
+				var count int64
+				db.Model(&TestModel{}).Where("name = ?", name).Count(&count)
+				if count != 0 {
+					t.Errorf("expected rollback after panic, got %d records", count)
+				}
+			})
+		}
+	}
+}
+
+func TestCurrentKeepsContextBinding(t *testing.T) {
+	db := setupTestDB(t)
+	reqCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ctx := New(context.Background(), db.WithContext(reqCtx))
+	ctx = WithRequestID(ctx, "req-1")
+	ctx = WithValues(ctx, map[string]any{"tenant": "acme"})
+	ctx = WithQueryLog(ctx)
+	ctx = WithTrace(ctx)
+
+	err := WithTransaction(ctx, func(txCtx context.Context) error {
+		Tag(txCtx, "import")
+		txCtx = WithValues(txCtx, map[string]any{"step": 1})
+		if Current(txCtx).Statement.Context != reqCtx {
+			t.Error("expected the transaction DB to stay bound to the request context")
+		}
+
+		cancel()
+		var count int64
+		return Current(txCtx).Model(&TestModel{}).Count(&count).Error
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancelled context to abort the query, got %v", err)
+	}
+}
+
+func TestWithDeferCleanupTwice(t *testing.T) {
+	db, pool := setupPoolTestDB(t)
+	ctx := New(context.Background(), db)
+
+	calls := 0
+	var err error
+	txCtx, cleanup := WithDefer(ctx)
+	OnSuccess(txCtx, func() { calls++ })
+	if err = Current(txCtx).Create(&TestModel{Name: "twice"}).Error; err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	cleanup(&err)
+	cleanup(&err)
+
+	if err != nil {
+		t.Errorf("expected the second cleanup to be a no-op, got %v", err)
+	}
+	if n := atomic.LoadInt64(&pool.committed); n != 1 {
+		t.Errorf("expected exactly one commit, got %d", n)
+	}
+	if n := atomic.LoadInt64(&pool.rolledBack); n != 0 {
+		t.Errorf("expected no rollback, got %d", n)
+	}
+	if calls != 1 {
+		t.Errorf("expected the callback to run once, got %d", calls)
+	}
+}
+
+func TestCallbacksFired(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	if CallbacksFired(ctx) {
+		t.Error("expected false outside a transaction")
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		var err error
+		txCtx, cleanup := WithDefer(ctx)
+		var firedInCallback bool
+		OnSuccess(txCtx, func() { firedInCallback = CallbacksFired(txCtx) })
+
+		if CallbacksFired(txCtx) {
+			t.Error("expected false before the cleanup runs")
+		}
+		cleanup(&err)
+		if err != nil {
+			t.Fatalf("cleanup failed: %v", err)
+		}
+
+		if !CallbacksFired(txCtx) {
+			t.Error("expected true after the cleanup committed")
+		}
+		if !firedInCallback {
+			t.Error("expected true from within a callback")
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		err := errors.New("fail")
+		txCtx, cleanup := WithDefer(ctx)
+		OnSuccess(txCtx, func() {})
+		cleanup(&err)
 
-		if successCount != numTransactions {
-			t.Errorf("expected %d successful callbacks, got %d", numTransactions, successCount)
+		if CallbacksFired(txCtx) {
+			t.Error("expected false after a rollback")
 		}
 	})
 }
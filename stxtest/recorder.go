@@ -0,0 +1,66 @@
+package stxtest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/restayway/stx"
+)
+
+// Recorder records which OnSuccess callbacks fired, and in what order, so
+// tests can assert on callbacks without ad-hoc flags. Create one with
+// RecordingContext.
+type Recorder struct {
+	ctx context.Context
+
+	mu    sync.Mutex
+	fired []string
+}
+
+// RecordingContext returns a context to start transactions from and a
+// Recorder observing their callbacks:
+//
+//	ctx, rec := stxtest.RecordingContext(ctx)
+//	err := stx.WithTransaction(ctx, func(txCtx context.Context) error {
+//	    stx.OnSuccess(txCtx, rec.Callback("email"))
+//	    stx.OnSuccess(txCtx, rec.Callback("audit"))
+//	    return nil
+//	})
+//	// rec.Fired() is []string{"email", "audit"}
+func RecordingContext(ctx context.Context) (context.Context, *Recorder) {
+	ctx = stx.WithTrace(ctx)
+	return ctx, &Recorder{ctx: ctx}
+}
+
+// Callback returns an OnSuccess callback that records name when it fires.
+func (r *Recorder) Callback(name string) func() {
+	return func() {
+		r.mu.Lock()
+		r.fired = append(r.fired, name)
+		r.mu.Unlock()
+	}
+}
+
+// Fired returns the names of the callbacks created with Callback that have
+// fired, in the order they fired.
+func (r *Recorder) Fired() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fired := make([]string, len(r.fired))
+	copy(fired, r.fired)
+	return fired
+}
+
+// Count returns the number of OnSuccess callbacks that have run in
+// transactions started from the recording context, including callbacks not
+// created with Callback, such as those registered by the code under test.
+func (r *Recorder) Count() int {
+	n := 0
+	for _, event := range stx.TraceEvents(r.ctx) {
+		if event.Kind == stx.TraceCallback {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,57 @@
+package stxtest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/restayway/stx"
+)
+
+func TestRecorder(t *testing.T) {
+	ctx := stx.New(context.Background(), setupTestDB(t))
+
+	t.Run("callbacks fire in order", func(t *testing.T) {
+		recCtx, rec := RecordingContext(ctx)
+
+		err := stx.WithTransaction(recCtx, func(txCtx context.Context) error {
+			stx.OnSuccess(txCtx, rec.Callback("first"))
+			stx.OnSuccess(txCtx, func() {})
+			stx.OnSuccess(txCtx, rec.Callback("second"))
+			if fired := rec.Fired(); len(fired) != 0 {
+				t.Errorf("expected no callbacks before commit, got %v", fired)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		if fired := rec.Fired(); !reflect.DeepEqual(fired, []string{"first", "second"}) {
+			t.Errorf("expected first and second, got %v", fired)
+		}
+		if n := rec.Count(); n != 3 {
+			t.Errorf("expected three callbacks to run, got %d", n)
+		}
+	})
+
+	t.Run("rolled back", func(t *testing.T) {
+		recCtx, rec := RecordingContext(ctx)
+
+		err := stx.WithTransaction(recCtx, func(txCtx context.Context) error {
+			stx.OnSuccess(txCtx, rec.Callback("discarded"))
+			return errors.New("failed")
+		})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+
+		if fired := rec.Fired(); len(fired) != 0 {
+			t.Errorf("expected no callbacks to fire, got %v", fired)
+		}
+		if n := rec.Count(); n != 0 {
+			t.Errorf("expected no callbacks to run, got %d", n)
+		}
+	})
+}
@@ -0,0 +1,25 @@
+// Package stxtest provides test helpers for code using stx.
+package stxtest
+
+import (
+	"testing"
+
+	"github.com/restayway/stx"
+)
+
+// AssertNoLeaks fails the test if a transaction started with stx.Begin,
+// stx.BeginCancelable or stx.WithDefer has been left open, which usually means
+// a code path forgot to commit or roll back. It is meant to be registered with
+// t.Cleanup:
+//
+//	t.Cleanup(func() { stxtest.AssertNoLeaks(t) })
+//
+// The count of open transactions is global, so it is unreliable for tests
+// running in parallel with other transactional tests.
+func AssertNoLeaks(t testing.TB) {
+	t.Helper()
+
+	if n := stx.OpenTransactions(); n != 0 {
+		t.Errorf("stx: %d transaction(s) left open", n)
+	}
+}
@@ -0,0 +1,73 @@
+package stxtest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/restayway/stx"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// recordingTB records failures instead of failing the test
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (tb *recordingTB) Helper() {}
+
+func (tb *recordingTB) Errorf(format string, args ...interface{}) {
+	tb.errors = append(tb.errors, fmt.Sprintf(format, args...))
+}
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file:stxtest?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to connect database: %v", err)
+	}
+	return db
+}
+
+func TestAssertNoLeaks(t *testing.T) {
+	ctx := stx.New(context.Background(), setupTestDB(t))
+
+	t.Run("balanced", func(t *testing.T) {
+		txCtx := stx.Begin(ctx)
+		if err := stx.Commit(txCtx); err != nil {
+			t.Fatalf("commit failed: %v", err)
+		}
+
+		err := func() (err error) {
+			_, cleanup := stx.WithDefer(ctx)
+			defer cleanup(&err)
+			return nil
+		}()
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		tb := &recordingTB{TB: t}
+		AssertNoLeaks(tb)
+		if len(tb.errors) != 0 {
+			t.Errorf("expected no failures, got %v", tb.errors)
+		}
+	})
+
+	t.Run("left open", func(t *testing.T) {
+		txCtx := stx.Begin(ctx)
+
+		tb := &recordingTB{TB: t}
+		AssertNoLeaks(tb)
+		if len(tb.errors) != 1 {
+			t.Errorf("expected one failure, got %v", tb.errors)
+		}
+
+		stx.Rollback(txCtx)
+		AssertNoLeaks(t)
+	})
+}
@@ -0,0 +1,31 @@
+package stx
+
+import "context"
+
+// OnSuccessTx registers fn to run in a fresh transaction of its own once the
+// transaction in ctx commits, for post-commit work that is itself database
+// work and should be atomic, such as updating a projection:
+//
+//	stx.OnSuccessTx(txCtx, func(ctx context.Context) error {
+//	    return stx.Current(ctx).Create(&OrderSummary{OrderID: order.ID}).Error
+//	})
+//
+// The transaction begins on the root DB, after the enclosing transaction has
+// committed, so fn sees its writes and a failure of fn cannot undo them.
+// As a callback fn cannot return its error to the caller; a failure is
+// logged through the DB's GORM logger instead. Like OnSuccess, fn is dropped
+// if the transaction rolls back, and runs right away outside a transaction.
+func OnSuccessTx(ctx context.Context, fn func(ctx context.Context) error) {
+	if ctx == nil || fn == nil {
+		return
+	}
+
+	addCallback(ctx, func() {
+		err := Detached(ctx, func(rootCtx context.Context) error {
+			return WithTransaction(rootCtx, fn)
+		})
+		if db := Current(ctx); err != nil && db != nil && db.Logger != nil {
+			db.Logger.Error(ctx, "stx: OnSuccessTx transaction failed: %v", err)
+		}
+	})
+}
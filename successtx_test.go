@@ -0,0 +1,93 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestOnSuccessTx(t *testing.T) {
+	log := &capturingLogger{}
+	db := setupTestDB(t).Session(&gorm.Session{Logger: log})
+	ctx := New(context.Background(), db)
+
+	t.Run("runs in a separate transaction after commit", func(t *testing.T) {
+		var mainTx, projectionTx *gorm.DB
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			mainTx = Current(txCtx)
+			OnSuccessTx(txCtx, func(projCtx context.Context) error {
+				projectionTx = Current(projCtx)
+				var count int64
+				if err := Current(projCtx).Model(&TestModel{}).Where("name = ?", "order").Count(&count).Error; err != nil {
+					return err
+				}
+				if count != 1 {
+					t.Errorf("expected the committed order to be visible, got %d rows", count)
+				}
+				return Current(projCtx).Create(&TestModel{Name: "projection"}).Error
+			})
+			return Current(txCtx).Create(&TestModel{Name: "order"}).Error
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		if projectionTx == nil {
+			t.Fatal("expected the projection transaction to run")
+		}
+		if projectionTx.Statement.ConnPool == mainTx.Statement.ConnPool {
+			t.Error("expected the projection to run in its own transaction")
+		}
+		var count int64
+		db.Model(&TestModel{}).Where("name = ?", "projection").Count(&count)
+		if count != 1 {
+			t.Errorf("expected the projection row to be committed, got %d rows", count)
+		}
+	})
+
+	t.Run("failure does not undo the main commit", func(t *testing.T) {
+		log.errors = nil
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			OnSuccessTx(txCtx, func(projCtx context.Context) error {
+				if err := Current(projCtx).Create(&TestModel{Name: "partial projection"}).Error; err != nil {
+					return err
+				}
+				return errors.New("projection failed")
+			})
+			return Current(txCtx).Create(&TestModel{Name: "kept"}).Error
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		var kept, partial int64
+		db.Model(&TestModel{}).Where("name = ?", "kept").Count(&kept)
+		db.Model(&TestModel{}).Where("name = ?", "partial projection").Count(&partial)
+		if kept != 1 {
+			t.Errorf("expected the main commit to stand, got %d rows", kept)
+		}
+		if partial != 0 {
+			t.Errorf("expected the failed projection to roll back, got %d rows", partial)
+		}
+		if len(log.errors) != 1 || !strings.Contains(log.errors[0], "projection failed") {
+			t.Errorf("expected the failure to be logged, got %v", log.errors)
+		}
+	})
+
+	t.Run("dropped on rollback", func(t *testing.T) {
+		ran := false
+		WithTransaction(ctx, func(txCtx context.Context) error {
+			OnSuccessTx(txCtx, func(context.Context) error {
+				ran = true
+				return nil
+			})
+			return errors.New("rollback")
+		})
+		if ran {
+			t.Error("expected fn not to run after a rollback")
+		}
+	})
+}
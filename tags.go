@@ -0,0 +1,80 @@
+package stx
+
+import (
+	"context"
+	"strings"
+)
+
+// Tag attaches tag to the transaction in ctx, such as a tenant or feature
+// flag. Tags are additive and duplicates are ignored. Tags of enclosing
+// transactions are visible from nested ones. Outside a transaction, and in a
+// foreign transaction resolved through Bridge, Tag does nothing.
+//
+// Tags are read with Tags by code holding the transaction's context, and are
+// appended to the warnings and errors stx logs about the transaction. They are
+// not passed to hooks: an OnBegin hook runs before the transaction's code can
+// tag it, so Tags there only returns the tags of enclosing transactions.
+func Tag(ctx context.Context, tag string) {
+	stx := txState(ctx)
+	if stx == nil || tag == "" {
+		return
+	}
+
+	stx.mu.Lock()
+	defer stx.mu.Unlock()
+	for _, t := range stx.tags {
+		if t == tag {
+			return
+		}
+	}
+	stx.tags = append(stx.tags, tag)
+}
+
+// Tags returns the tags attached to the transaction in ctx and the
+// transactions enclosing it, outermost first, or nil outside a transaction.
+func Tags(ctx context.Context) []string {
+	stx := txState(ctx)
+	if stx == nil {
+		return nil
+	}
+	return stx.allTags()
+}
+
+// allTags returns the deduplicated tags of stx and its enclosing
+// transactions, outermost first
+func (stx *STX) allTags() []string {
+	if stx == nil {
+		return nil
+	}
+
+	var chain []*STX
+	for s := stx; s != nil; s = s.parent {
+		chain = append(chain, s)
+		if s.depth <= 1 {
+			break
+		}
+	}
+
+	var tags []string
+	seen := make(map[string]bool)
+	for i := len(chain) - 1; i >= 0; i-- {
+		chain[i].mu.RLock()
+		for _, tag := range chain[i].tags {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+		chain[i].mu.RUnlock()
+	}
+	return tags
+}
+
+// tagSuffix formats the tags of stx for log messages
+func (stx *STX) tagSuffix() string {
+	tags := stx.allTags()
+	if len(tags) == 0 {
+		return ""
+	}
+	return " [tags: " + strings.Join(tags, ", ") + "]"
+}
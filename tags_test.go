@@ -0,0 +1,97 @@
+package stx
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestTags(t *testing.T) {
+	log := &capturingLogger{}
+	db := setupTestDB(t).Session(&gorm.Session{Logger: log})
+	ctx := New(context.Background(), db)
+
+	Tag(ctx, "ignored")
+	if tags := Tags(ctx); tags != nil {
+		t.Errorf("expected no tags outside a transaction, got %v", tags)
+	}
+
+	err := WithTransaction(ctx, func(txCtx context.Context) error {
+		Tag(txCtx, "tenant:acme")
+		Tag(txCtx, "beta")
+		Tag(txCtx, "tenant:acme")
+
+		if tags := Tags(txCtx); strings.Join(tags, ",") != "tenant:acme,beta" {
+			t.Errorf("expected deduplicated tags, got %v", tags)
+		}
+
+		return WithTransaction(txCtx, func(innerCtx context.Context) error {
+			Tag(innerCtx, "nested")
+			if tags := Tags(innerCtx); strings.Join(tags, ",") != "tenant:acme,beta,nested" {
+				t.Errorf("expected nested transaction to see enclosing tags, got %v", tags)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+
+	t.Run("rollback log", func(t *testing.T) {
+		if err := db.Create(&TestModel{ID: 1, Name: "existing"}).Error; err != nil {
+			t.Fatalf("failed to create record: %v", err)
+		}
+
+		err := WithTransaction(WithRollbackSQLLog(ctx), func(txCtx context.Context) error {
+			Tag(txCtx, "tenant:acme")
+			return Current(txCtx).Create(&TestModel{ID: 1, Name: "duplicate"}).Error
+		})
+		if err == nil {
+			t.Fatal("expected constraint violation")
+		}
+		if len(log.errors) != 1 || !strings.Contains(log.errors[0], "[tags: tenant:acme]") {
+			t.Errorf("expected rollback log to include the tags, got %v", log.errors)
+		}
+	})
+
+	t.Run("savepoint", func(t *testing.T) {
+		txCtx := Begin(ctx)
+		defer Rollback(txCtx)
+		Tag(txCtx, "outer")
+
+		spCtx := Begin(txCtx)
+		if tags := Tags(spCtx); len(tags) != 1 || tags[0] != "outer" {
+			t.Errorf("expected savepoint to see the enclosing tags, got %v", tags)
+		}
+		if err := Rollback(spCtx); err != nil {
+			t.Fatalf("rollback failed: %v", err)
+		}
+	})
+
+	t.Run("OnBegin hook sees enclosing tags", func(t *testing.T) {
+		beginHooksMu.Lock()
+		saved := beginHooks
+		beginHooksMu.Unlock()
+		defer func() {
+			beginHooksMu.Lock()
+			beginHooks = saved
+			beginHooksMu.Unlock()
+		}()
+
+		var seen [][]string
+		OnBegin(func(ctx context.Context) { seen = append(seen, Tags(ctx)) })
+
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			Tag(txCtx, "outer")
+			return WithTransaction(txCtx, func(context.Context) error { return nil })
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if len(seen) != 2 || len(seen[0]) != 0 || strings.Join(seen[1], ",") != "outer" {
+			t.Errorf("expected only the nested hook to see the outer tag, got %v", seen)
+		}
+	})
+}
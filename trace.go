@@ -0,0 +1,109 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const traceContextKey contextKey = "stx:trace"
+
+// TraceKind identifies the kind of a TraceEvent
+type TraceKind string
+
+const (
+	// TraceBegin is recorded when a transaction or savepoint begins
+	TraceBegin TraceKind = "begin"
+	// TraceQueryError is recorded when a statement in a transaction fails
+	TraceQueryError TraceKind = "query error"
+	// TraceCommit is recorded when a transaction or savepoint commits
+	TraceCommit TraceKind = "commit"
+	// TraceRollback is recorded when a transaction or savepoint rolls back
+	TraceRollback TraceKind = "rollback"
+	// TraceCallback is recorded after each OnSuccess callback has run
+	TraceCallback TraceKind = "callback"
+)
+
+// TraceEvent is an entry in the timeline recorded with WithTrace
+type TraceEvent struct {
+	Time   time.Time
+	Kind   TraceKind
+	Detail string
+}
+
+// trace collects the events of transactions in a WithTrace scope
+type trace struct {
+	mu     sync.Mutex
+	events []TraceEvent
+}
+
+// record appends an event to the trace; it is a no-op on a nil trace
+func (t *trace) record(kind TraceKind, format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+
+	event := TraceEvent{Time: time.Now(), Kind: kind, Detail: fmt.Sprintf(format, args...)}
+	t.mu.Lock()
+	t.events = append(t.events, event)
+	t.mu.Unlock()
+}
+
+// traceLogger is a GORM logger that records failing statements into a trace
+// before passing them on to the next logger
+type traceLogger struct {
+	wrappedLogger
+	trace *trace
+}
+
+func (l *traceLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &traceLogger{wrappedLogger: wrappedLogger{next: l.next.LogMode(level)}, trace: l.trace}
+}
+
+func (l *traceLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		sql, _ := fc()
+		l.trace.record(TraceQueryError, "%s: %v", sql, err)
+	}
+
+	l.next.Trace(ctx, begin, fc, err)
+}
+
+// WithTrace returns a context whose transactions record a timeline of their
+// lifecycle: begin, failed statements, commit or rollback, and each OnSuccess
+// callback, with timestamps. The timeline is available from TraceEvents, also
+// after the transactions have finished. It is a lightweight aid for local
+// debugging, for example of when callbacks run relative to the commit.
+func WithTrace(ctx context.Context) context.Context {
+	return context.WithValue(ctx, traceContextKey, &trace{})
+}
+
+// TraceEvents returns the events recorded in the WithTrace scope of ctx, in
+// order, or nil if tracing is not enabled.
+func TraceEvents(ctx context.Context) []TraceEvent {
+	t := traceFromContext(ctx)
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	events := make([]TraceEvent, len(t.events))
+	copy(events, t.events)
+	return events
+}
+
+// traceFromContext returns the trace stored with WithTrace
+func traceFromContext(ctx context.Context) *trace {
+	if ctx == nil {
+		return nil
+	}
+
+	t, _ := ctx.Value(traceContextKey).(*trace)
+	return t
+}
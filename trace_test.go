@@ -0,0 +1,109 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func traceKinds(events []TraceEvent) []TraceKind {
+	kinds := make([]TraceKind, len(events))
+	for i, event := range events {
+		kinds[i] = event.Kind
+	}
+	return kinds
+}
+
+func equalKinds(a, b []TraceKind) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWithTrace(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	t.Run("disabled by default", func(t *testing.T) {
+		if err := WithTransaction(ctx, func(txCtx context.Context) error { return nil }); err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if events := TraceEvents(ctx); events != nil {
+			t.Errorf("expected no events, got %v", events)
+		}
+	})
+
+	t.Run("commit and callbacks", func(t *testing.T) {
+		traceCtx := WithTrace(ctx)
+
+		err := WithTransaction(traceCtx, func(txCtx context.Context) error {
+			OnSuccess(txCtx, func() {})
+			OnSuccess(txCtx, func() {})
+			return Current(txCtx).Create(&TestModel{Name: "traced"}).Error
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+
+		events := TraceEvents(traceCtx)
+		want := []TraceKind{TraceBegin, TraceCommit, TraceCallback, TraceCallback}
+		if !equalKinds(traceKinds(events), want) {
+			t.Fatalf("expected %v, got %v", want, traceKinds(events))
+		}
+		for i := 1; i < len(events); i++ {
+			if events[i].Time.Before(events[i-1].Time) {
+				t.Errorf("event %d is timestamped before event %d", i, i-1)
+			}
+		}
+	})
+
+	t.Run("query error and rollback", func(t *testing.T) {
+		traceCtx := WithTrace(ctx)
+		errFailed := errors.New("failed")
+
+		err := WithTransaction(traceCtx, func(txCtx context.Context) error {
+			OnSuccess(txCtx, func() {})
+			if err := Current(txCtx).Exec("INSERT INTO missing_table VALUES (1)").Error; err == nil {
+				t.Error("expected query error")
+			}
+			return errFailed
+		})
+		if !errors.Is(err, errFailed) {
+			t.Fatalf("expected errFailed, got %v", err)
+		}
+
+		events := TraceEvents(traceCtx)
+		want := []TraceKind{TraceBegin, TraceQueryError, TraceRollback}
+		if !equalKinds(traceKinds(events), want) {
+			t.Fatalf("expected %v, got %v", want, traceKinds(events))
+		}
+	})
+
+	t.Run("nested and manual", func(t *testing.T) {
+		traceCtx := WithTrace(ctx)
+
+		txCtx := Begin(traceCtx)
+		err := WithTransaction(txCtx, func(nestedCtx context.Context) error { return nil })
+		if err != nil {
+			t.Fatalf("nested transaction failed: %v", err)
+		}
+		if err := Commit(txCtx); err != nil {
+			t.Fatalf("commit failed: %v", err)
+		}
+
+		events := TraceEvents(traceCtx)
+		want := []TraceKind{TraceBegin, TraceBegin, TraceCommit, TraceCommit}
+		if !equalKinds(traceKinds(events), want) {
+			t.Fatalf("expected %v, got %v", want, traceKinds(events))
+		}
+		if events[1].Detail != "depth 2" {
+			t.Errorf("expected nested begin at depth 2, got %q", events[1].Detail)
+		}
+	})
+}
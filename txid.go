@@ -0,0 +1,64 @@
+package stx
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sync"
+)
+
+var (
+	idGeneratorMu sync.RWMutex
+	// idGenerator is the generator set with SetIDGenerator, nil for the
+	// default
+	idGenerator func() string
+)
+
+// SetIDGenerator replaces the function that assigns IDs to transactions, for
+// example to reuse the ID scheme of a tracing system. The generator must be
+// safe for concurrent use. A nil generator restores the default, which
+// returns random UUID-like strings. Like OnBegin, it should be set during
+// initialization.
+func SetIDGenerator(generator func() string) {
+	idGeneratorMu.Lock()
+	idGenerator = generator
+	idGeneratorMu.Unlock()
+}
+
+// TxID returns the ID of the transaction in ctx, or an empty string outside a
+// transaction and for transactions not started by stx. Every top-level
+// transaction gets a new ID when it begins; nested transactions and
+// savepoints share the ID of the enclosing transaction, as they run in the
+// same database transaction. The ID is available to OnBegin hooks and stays
+// the same for the transaction's lifetime, which makes it suitable to
+// correlate logs, metrics and traces.
+func TxID(ctx context.Context) string {
+	stx := fromContext(ctx)
+	if stx == nil || !IsTx(ctx) {
+		return ""
+	}
+	return stx.id
+}
+
+// nextID returns an ID from the configured generator
+func nextID() string {
+	idGeneratorMu.RLock()
+	generator := idGenerator
+	idGeneratorMu.RUnlock()
+
+	if generator == nil {
+		return randomID()
+	}
+	return generator()
+}
+
+// randomID returns a random version 4 UUID
+func randomID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
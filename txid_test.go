@@ -0,0 +1,88 @@
+package stx
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTxID(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	if id := TxID(ctx); id != "" {
+		t.Errorf("expected no ID outside a transaction, got %q", id)
+	}
+
+	t.Run("distinct and stable", func(t *testing.T) {
+		uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+		seen := make(map[string]bool)
+
+		for i := 0; i < 3; i++ {
+			err := WithTransaction(ctx, func(txCtx context.Context) error {
+				id := TxID(txCtx)
+				if !uuid.MatchString(id) {
+					t.Errorf("expected a UUID-like ID, got %q", id)
+				}
+				if seen[id] {
+					t.Errorf("expected distinct IDs, got %q twice", id)
+				}
+				seen[id] = true
+
+				if err := Current(txCtx).Create(&TestModel{Name: "id"}).Error; err != nil {
+					return err
+				}
+				if again := TxID(txCtx); again != id {
+					t.Errorf("expected ID to stay %q, got %q", id, again)
+				}
+				return WithTransaction(txCtx, func(nestedCtx context.Context) error {
+					if nested := TxID(nestedCtx); nested != id {
+						t.Errorf("expected nested transaction to share %q, got %q", id, nested)
+					}
+					return nil
+				})
+			})
+			if err != nil {
+				t.Fatalf("transaction failed: %v", err)
+			}
+		}
+	})
+
+	t.Run("custom generator", func(t *testing.T) {
+		var n int64
+		SetIDGenerator(func() string {
+			return fmt.Sprintf("tx-%d", atomic.AddInt64(&n, 1))
+		})
+		defer SetIDGenerator(nil)
+
+		txCtx := Begin(ctx)
+		defer Rollback(txCtx)
+		if id := TxID(txCtx); id != "tx-1" {
+			t.Errorf("expected tx-1, got %q", id)
+		}
+	})
+
+	t.Run("visible to begin hooks", func(t *testing.T) {
+		beginHooksMu.Lock()
+		saved := beginHooks
+		beginHooksMu.Unlock()
+		defer func() {
+			beginHooksMu.Lock()
+			beginHooks = saved
+			beginHooksMu.Unlock()
+		}()
+
+		var hooked string
+		OnBegin(func(hookCtx context.Context) {
+			hooked = TxID(hookCtx)
+		})
+
+		txCtx := Begin(ctx)
+		defer Rollback(txCtx)
+		if hooked == "" || hooked != TxID(txCtx) {
+			t.Errorf("expected hook to see ID %q, got %q", TxID(txCtx), hooked)
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package stx
+
+import "context"
+
+// TxEvent describes a step in the life of a transaction observed with Watch
+type TxEvent int
+
+const (
+	// TxBegun is sent when the watch starts, as the transaction has begun
+	TxBegun TxEvent = iota
+	// TxCommitted is sent when the transaction, or its savepoint, commits
+	TxCommitted
+	// TxRolledBack is sent when the transaction is rolled back, including
+	// after a failed commit or when it exceeded its maximum lifetime
+	TxRolledBack
+)
+
+func (e TxEvent) String() string {
+	switch e {
+	case TxBegun:
+		return "begun"
+	case TxCommitted:
+		return "committed"
+	case TxRolledBack:
+		return "rolled back"
+	}
+	return "unknown"
+}
+
+// Watch returns a channel that reports the transaction in ctx to observers in
+// other goroutines: it receives TxBegun, then TxCommitted or TxRolledBack when
+// the transaction ends, and is closed afterwards. The channel is buffered, so
+// the transaction never waits for the observer. Outside a transaction, in a
+// foreign transaction resolved through Bridge, whose end stx does not observe,
+// or once the transaction has finished, the returned channel is already
+// closed.
+func Watch(ctx context.Context) <-chan TxEvent {
+	ch := make(chan TxEvent, 2)
+
+	stx := txState(ctx)
+	if stx == nil {
+		close(ch)
+		return ch
+	}
+
+	stx.mu.Lock()
+	defer stx.mu.Unlock()
+	if stx.ended || stx.finished || stx.aborted != nil {
+		close(ch)
+		return ch
+	}
+
+	ch <- TxBegun
+	stx.watchers = append(stx.watchers, ch)
+	return ch
+}
+
+// notifyWatchers sends the final event to watchers and closes their channels
+func notifyWatchers(watchers []chan TxEvent, event TxEvent) {
+	for _, ch := range watchers {
+		ch <- event
+		close(ch)
+	}
+}
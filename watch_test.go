@@ -0,0 +1,78 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// receiveEvents collects the events sent on ch until it is closed
+func receiveEvents(t *testing.T, ch <-chan TxEvent) []TxEvent {
+	t.Helper()
+
+	var events []TxEvent
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		case <-timeout:
+			t.Errorf("channel not closed, received %v", events)
+			return events
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	t.Run("Begin and Commit", func(t *testing.T) {
+		txCtx := Begin(ctx)
+		ch := Watch(txCtx)
+
+		done := make(chan []TxEvent)
+		go func() { done <- receiveEvents(t, ch) }()
+
+		if err := Commit(txCtx); err != nil {
+			t.Fatalf("commit failed: %v", err)
+		}
+
+		events := <-done
+		if len(events) != 2 || events[0] != TxBegun || events[1] != TxCommitted {
+			t.Errorf("expected begun and committed, got %v", events)
+		}
+	})
+
+	t.Run("WithTransaction rollback", func(t *testing.T) {
+		var ch <-chan TxEvent
+		WithTransaction(ctx, func(txCtx context.Context) error {
+			ch = Watch(txCtx)
+			return errors.New("rollback")
+		})
+
+		events := receiveEvents(t, ch)
+		if len(events) != 2 || events[0] != TxBegun || events[1] != TxRolledBack {
+			t.Errorf("expected begun and rolled back, got %v", events)
+		}
+	})
+
+	t.Run("finished transaction", func(t *testing.T) {
+		txCtx := Begin(ctx)
+		Rollback(txCtx)
+
+		if events := receiveEvents(t, Watch(txCtx)); len(events) != 0 {
+			t.Errorf("expected closed channel, got %v", events)
+		}
+	})
+
+	t.Run("outside a transaction", func(t *testing.T) {
+		if events := receiveEvents(t, Watch(ctx)); len(events) != 0 {
+			t.Errorf("expected closed channel, got %v", events)
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package stx
+
+import (
+	"context"
+	"sync"
+)
+
+// writeOnce is the result of a WriteOnce key in a transaction
+type writeOnce struct {
+	once sync.Once
+	err  error
+	// owner is the transaction or savepoint fn ran in
+	owner *STX
+}
+
+// WriteOnce runs fn the first time key is seen in the transaction in ctx and
+// returns its result; later calls with the same key return that result
+// without running fn again. Keys are scoped to the outermost transaction, so
+// convergent code paths, including nested transactions, can each ensure a
+// write without inserting it twice. If the savepoint fn ran in is rolled
+// back, the key is forgotten so that the write can be made again. Outside a
+// transaction, and in a foreign transaction resolved through Bridge, fn runs
+// on every call.
+func WriteOnce(ctx context.Context, key string, fn func() error) error {
+	if fn == nil {
+		return ErrNilFunc
+	}
+
+	stx := txState(ctx)
+	if stx == nil {
+		return fn()
+	}
+
+	root := stx.outermost()
+	root.mu.Lock()
+	if root.writes == nil {
+		root.writes = make(map[string]*writeOnce)
+	}
+	w, ok := root.writes[key]
+	if !ok {
+		w = &writeOnce{owner: stx}
+		root.writes[key] = w
+	}
+	root.mu.Unlock()
+
+	w.once.Do(func() {
+		w.err = fn()
+	})
+	return w.err
+}
+
+// forgetWrites removes the WriteOnce keys written in the rolled back
+// savepoint stx or in transactions nested in it
+func (stx *STX) forgetWrites() {
+	if stx.depth <= 1 {
+		return
+	}
+
+	root := stx.outermost()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	for key, w := range root.writes {
+		for owner := w.owner; owner != nil && owner.depth >= stx.depth; owner = owner.parent {
+			if owner == stx {
+				delete(root.writes, key)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package stx
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWriteOnce(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := New(context.Background(), db)
+
+	count := func(name string) int64 {
+		var n int64
+		db.Model(&TestModel{}).Where("name = ?", name).Count(&n)
+		return n
+	}
+
+	t.Run("runs once per transaction", func(t *testing.T) {
+		runs := 0
+		insert := func(txCtx context.Context) error {
+			return WriteOnce(txCtx, "user:1", func() error {
+				runs++
+				return Current(txCtx).Create(&TestModel{Name: "once"}).Error
+			})
+		}
+
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			if err := insert(txCtx); err != nil {
+				return err
+			}
+			if err := insert(txCtx); err != nil {
+				return err
+			}
+			return WithTransaction(txCtx, insert)
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if runs != 1 || count("once") != 1 {
+			t.Errorf("expected one write, got %d runs and %d rows", runs, count("once"))
+		}
+
+		// The next transaction starts over
+		if err := WithTransaction(ctx, insert); err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if runs != 2 {
+			t.Errorf("expected the key to be scoped to the transaction, got %d runs", runs)
+		}
+	})
+
+	t.Run("cached error", func(t *testing.T) {
+		errFailed := errors.New("failed")
+		runs := 0
+		txCtx := Begin(ctx)
+		defer Rollback(txCtx)
+
+		for i := 0; i < 2; i++ {
+			err := WriteOnce(txCtx, "failing", func() error {
+				runs++
+				return errFailed
+			})
+			if !errors.Is(err, errFailed) {
+				t.Errorf("expected the cached error, got %v", err)
+			}
+		}
+		if runs != 1 {
+			t.Errorf("expected one run, got %d", runs)
+		}
+	})
+
+	t.Run("rolled back savepoint forgets key", func(t *testing.T) {
+		runs := 0
+		err := WithTransaction(ctx, func(txCtx context.Context) error {
+			write := func(c context.Context) error {
+				return WriteOnce(c, "retried", func() error {
+					runs++
+					return Current(c).Create(&TestModel{Name: "retried"}).Error
+				})
+			}
+
+			err := WithTransaction(txCtx, func(nestedCtx context.Context) error {
+				if err := write(nestedCtx); err != nil {
+					return err
+				}
+				return errors.New("nested failed")
+			})
+			if err == nil {
+				t.Error("expected the nested transaction to fail")
+			}
+			return write(txCtx)
+		})
+		if err != nil {
+			t.Fatalf("transaction failed: %v", err)
+		}
+		if runs != 2 || count("retried") != 1 {
+			t.Errorf("expected the write to be redone once, got %d runs and %d rows", runs, count("retried"))
+		}
+	})
+
+	t.Run("outside a transaction", func(t *testing.T) {
+		runs := 0
+		for i := 0; i < 2; i++ {
+			WriteOnce(ctx, "free", func() error { runs++; return nil })
+		}
+		if runs != 2 {
+			t.Errorf("expected fn to run every time, got %d", runs)
+		}
+	})
+}